@@ -36,10 +36,14 @@ const batchSize = 10000
 func main() {
 	logPath := flag.String("log-path", ".", "Path to the directory with log files")
 	apiHost := flag.String("api-host", "http://localhost:8080", "API host URL")
+	apiToken := flag.String("token", os.Getenv("PROXYCHECKER_TOKEN"), "API token with the visits:write scope (default $PROXYCHECKER_TOKEN)")
 	flag.Parse()
 	log.Println("Agent started...")
 	log.Printf("Log directory: %s", *logPath)
 	log.Printf("API Host: %s", *apiHost)
+	if *apiToken == "" {
+		log.Println("Warning: no API token set (--token or PROXYCHECKER_TOKEN), the API will reject the logs")
+	}
 
 	logFile, err := findLatestLogFile(*logPath)
 	if err != nil {
@@ -66,7 +70,7 @@ func main() {
 
 	log.Printf("Found %d new log entries to send.", len(logs))
 
-	err = sendLogsInBatches(logs, *apiHost)
+	err = sendLogsInBatches(logs, *apiHost, *apiToken)
 	if err != nil {
 		log.Fatalf("Failed to send logs: %v", err)
 	}
@@ -250,7 +254,7 @@ func saveState(timestamp time.Time) error {
 	return os.WriteFile(stateFileName, data, 0644)
 }
 
-func sendLogsInBatches(logs []ProxyVisitLogs, apiHost string) error {
+func sendLogsInBatches(logs []ProxyVisitLogs, apiHost, apiToken string) error {
 	for i := 0; i < len(logs); i += batchSize {
 		end := i + batchSize
 		if end > len(logs) {
@@ -258,7 +262,7 @@ func sendLogsInBatches(logs []ProxyVisitLogs, apiHost string) error {
 		}
 
 		batch := logs[i:end]
-		if err := sendBatch(batch, apiHost); err != nil {
+		if err := sendBatch(batch, apiHost, apiToken); err != nil {
 			return err
 		}
 	}
@@ -266,13 +270,22 @@ func sendLogsInBatches(logs []ProxyVisitLogs, apiHost string) error {
 	return nil
 }
 
-func sendBatch(batch []ProxyVisitLogs, apiHost string) error {
+func sendBatch(batch []ProxyVisitLogs, apiHost, apiToken string) error {
 	data, err := json.Marshal(batch)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(apiHost+"/api/proxyVisits", "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequest(http.MethodPost, apiHost+"/api/proxyVisits", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
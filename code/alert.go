@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Типы алертов
+const (
+	AlertProxyDown = "proxy_down"
+	AlertIPStuck   = "ip_stuck"
+	AlertLowSpeed  = "low_speed"
+	AlertFlapping  = "flapping"
+	AlertSLABreach = "sla_breach"
+)
+
+// Состояния алерта
+const (
+	AlertPending  = "pending"  // условие выполняется, но уведомление еще не отправлено
+	AlertOpen     = "open"     // уведомление отправлено
+	AlertResolved = "resolved" // условие больше не выполняется
+)
+
+// Значения по умолчанию для детектора флаппинга
+const (
+	defaultFlapThreshold     = 4  // смен состояния за окно
+	defaultFlapWindowMinutes = 30 // окно, мин
+)
+
+// Через сколько часов без смены IP отправляется алерт ip_stuck
+const stuckIPAlertHours = 24
+
+// Alert - состояние алерта по прокси. На прокси и тип одновременно бывает
+// не больше одного нерешенного алерта.
+type Alert struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	ProxyID     string    `json:"proxy_id" gorm:"index"`
+	Type        string    `json:"type" gorm:"index"`
+	Status      string    `json:"status" gorm:"index"`
+	Message     string    `json:"message"`
+	OpenedAt    time.Time `json:"opened_at" gorm:"index"`
+	NotifiedAt  time.Time `json:"notified_at"` // последнее уведомление
+	NotifyCount int       `json:"notify_count"`
+	ResolvedAt  time.Time `json:"resolved_at"`
+}
+
+// AlertFilters - фильтры списка алертов. Status "active" - pending и open.
+type AlertFilters struct {
+	ProxyID  string
+	Type     string
+	Status   string
+	Page     int
+	PageSize int
+}
+
+func (Alert) TableName() string {
+	return "alerts"
+}
+
+func (a *Alert) Save(db *gorm.DB) error {
+	return db.Save(a).Error
+}
+
+// List возвращает алерты с пагинацией, новые первыми.
+func (a *Alert) List(filters AlertFilters, db *gorm.DB) ([]Alert, int64, error) {
+	alerts := []Alert{}
+	query := db.Model(a)
+	if filters.ProxyID != "" {
+		query = query.Where("proxy_id = ?", filters.ProxyID)
+	}
+	if filters.Type != "" {
+		query = query.Where("type = ?", filters.Type)
+	}
+	switch filters.Status {
+	case "":
+	case "active":
+		query = query.Where("status <> ?", AlertResolved)
+	default:
+		query = query.Where("status = ?", filters.Status)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return alerts, 0, err
+	}
+
+	offset := 0
+	if filters.Page > 1 {
+		offset = filters.PageSize * (filters.Page - 1)
+	}
+	err := query.Order("opened_at desc").Limit(filters.PageSize).Offset(offset).Find(&alerts).Error
+	return alerts, count, err
+}
+
+// AlertManager решает, когда отправлять уведомления о состоянии прокси:
+// не дублирует уже отправленные алерты, повторяет их не чаще
+// AlertRenotifyMinutes, ждет AlertMinDownMinutes перед алертом о падении
+// и сворачивает частые переходы down/up в один алерт "flapping".
+// Смены состояния для детектора флаппинга берутся из сохраненных алертов
+// proxy_down, поэтому переживают перезапуск.
+type AlertManager struct {
+	db       *gorm.DB
+	notifier *NotificationService
+
+	locks sync.Map // прокси -> *sync.Mutex, алерты одного прокси меняются по очереди
+
+	outages *outageCorrelator
+}
+
+func NewAlertManager(db *gorm.DB, notifier *NotificationService) *AlertManager {
+	return &AlertManager{
+		db:       db,
+		notifier: notifier,
+		outages:  &outageCorrelator{db: db, notifier: notifier},
+	}
+}
+
+// lock блокирует алерты одного прокси и возвращает функцию разблокировки.
+// Проверки разных прокси не ждут друг друга на запросах к базе.
+func (m *AlertManager) lock(proxyID string) func() {
+	mu, _ := m.locks.LoadOrStore(proxyID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// ProxyDown вызывается на каждой проверке, пока прокси мертв.
+func (m *AlertManager) ProxyDown(p *Proxy, errMsg string, stg *Settings) {
+	defer m.lock(p.Id)()
+
+	now := time.Now()
+	alert, err := m.find(p.Id, AlertProxyDown)
+	if err != nil {
+		return
+	}
+	// Падение сохранится с алертом в raise, здесь учитываем его заранее
+	pending := 0
+	if alert == nil {
+		pending = 1
+	}
+	flapping, _ := m.checkFlapping(now, p, pending, stg)
+
+	var notify func()
+	if stg.NotifyOnDown && !flapping {
+		// Одновременные падения уходят одним сообщением об аварии
+		notify = func() { m.outages.add(*p, errMsg, stg) }
+	}
+	delay := time.Duration(stg.AlertMinDownMinutes) * time.Minute
+	m.raise(now, alert, p.Id, AlertProxyDown, errMsg, delay, stg, notify)
+}
+
+// ProxyUp вызывается на каждой успешной проверке. Уведомление о восстановлении
+// уходит, только если о падении уже сообщали.
+func (m *AlertManager) ProxyUp(p *Proxy, stg *Settings) {
+	defer m.lock(p.Id)()
+
+	now := time.Now()
+	alert, err := m.find(p.Id, AlertProxyDown)
+	if err != nil {
+		return
+	}
+	// Восстановление сохранится при закрытии алерта ниже
+	pending := 0
+	if alert != nil {
+		pending = 1
+	}
+	flapping, settled := m.checkFlapping(now, p, pending, stg)
+
+	notified := false
+	if alert != nil {
+		notified = alert.Status == AlertOpen
+		m.resolve(now, alert)
+	}
+	// После флаппинга сообщаем, в каком состоянии прокси успокоился
+	if stg.NotifyOnRecovery && !flapping && (notified || settled) {
+		m.notifier.NotifyProxyRecovered(p)
+	}
+}
+
+// IPStuck обновляет алерт о залипшем IP по результату проверки.
+func (m *AlertManager) IPStuck(p *Proxy, stuckIP string, hours int, stg *Settings) {
+	defer m.lock(p.Id)()
+
+	alert, err := m.find(p.Id, AlertIPStuck)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if !p.Stack || hours < stuckIPAlertHours {
+		m.resolve(now, alert)
+		return
+	}
+
+	var notify func()
+	if stg.NotifyOnIPStuck {
+		notify = func() { m.notifier.NotifyIPStuck(p, stuckIP, hours) }
+	}
+	m.raise(now, alert, p.Id, AlertIPStuck, stuckIP, 0, stg, notify)
+}
+
+// LowSpeed обновляет алерт о низкой скорости по результату замера.
+func (m *AlertManager) LowSpeed(p *Proxy, stg *Settings) {
+	defer m.lock(p.Id)()
+
+	alert, err := m.find(p.Id, AlertLowSpeed)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if p.Speed <= 0 || p.Speed >= stg.LowSpeedThreshold {
+		m.resolve(now, alert)
+		return
+	}
+
+	var notify func()
+	if stg.NotifyOnLowSpeed {
+		notify = func() { m.notifier.NotifyLowSpeed(p, stg.LowSpeedThreshold) }
+	}
+	m.raise(now, alert, p.Id, AlertLowSpeed, "", 0, stg, notify)
+}
+
+// SLABreach открывает алерт, когда доступность прокси ниже цели SLA,
+// и закрывает, когда она восстановилась.
+func (m *AlertManager) SLABreach(p *Proxy, a Availability, stg *Settings) {
+	defer m.lock(p.Id)()
+
+	alert, err := m.find(p.Id, AlertSLABreach)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if !a.Breach {
+		m.resolve(now, alert)
+		return
+	}
+
+	var notify func()
+	if stg.NotifyOnSLABreach {
+		notify = func() { m.notifier.NotifySLABreach(p, a) }
+	}
+	m.raise(now, alert, p.Id, AlertSLABreach, fmt.Sprintf("%.2f%% < %.2f%%", a.Availability, a.SLATarget), 0, stg, notify)
+}
+
+// Forget закрывает алерты удаленного прокси.
+func (m *AlertManager) Forget(proxyID string) {
+	defer m.lock(proxyID)()
+
+	err := m.db.Model(&Alert{}).
+		Where("proxy_id = ? AND status <> ?", proxyID, AlertResolved).
+		Updates(map[string]any{"status": AlertResolved, "resolved_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("Alerts: failed to resolve alerts of deleted proxy %s: %v", proxyID, err)
+	}
+}
+
+// find возвращает нерешенный алерт прокси указанного типа или nil.
+func (m *AlertManager) find(proxyID, alertType string) (*Alert, error) {
+	var alerts []Alert
+	err := m.db.Where("proxy_id = ? AND type = ? AND status <> ?", proxyID, alertType, AlertResolved).
+		Order("opened_at").
+		Limit(1).
+		Find(&alerts).Error
+	if err != nil {
+		log.Printf("Alerts: failed to load %s alert for proxy %s: %v", alertType, proxyID, err)
+		return nil, err
+	}
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	return &alerts[0], nil
+}
+
+// raise открывает алерт или продлевает открытый. notify вызывается, когда
+// условие держится дольше delay, и затем не чаще AlertRenotifyMinutes.
+// notify == nil - уведомление выключено или подавлено, алерт только учитывается.
+func (m *AlertManager) raise(now time.Time, alert *Alert, proxyID, alertType, message string, delay time.Duration, stg *Settings, notify func()) {
+	if alert == nil {
+		alert = &Alert{
+			ID:       uuid.NewString(),
+			ProxyID:  proxyID,
+			Type:     alertType,
+			Status:   AlertPending,
+			OpenedAt: now,
+		}
+	}
+	alert.Message = message
+
+	renotify := time.Duration(stg.AlertRenotifyMinutes) * time.Minute
+	send := false
+	switch {
+	case notify == nil:
+	case alert.Status == AlertPending:
+		send = now.Sub(alert.OpenedAt) >= delay
+	case alert.Status == AlertOpen:
+		send = renotify > 0 && now.Sub(alert.NotifiedAt) >= renotify
+	}
+	if send {
+		notify()
+		alert.Status = AlertOpen
+		alert.NotifiedAt = now
+		alert.NotifyCount++
+	}
+
+	if err := alert.Save(m.db); err != nil {
+		log.Printf("Alerts: failed to save %s alert for proxy %s: %v", alertType, proxyID, err)
+	}
+}
+
+// resolve закрывает алерт, если он есть.
+func (m *AlertManager) resolve(now time.Time, alert *Alert) {
+	if alert == nil {
+		return
+	}
+	alert.Status = AlertResolved
+	alert.ResolvedAt = now
+	if err := alert.Save(m.db); err != nil {
+		log.Printf("Alerts: failed to resolve %s alert for proxy %s: %v", alert.Type, alert.ProxyID, err)
+	}
+}
+
+// checkFlapping открывает алерт "flapping", если за окно набралось
+// FlapThreshold смен состояния, и закрывает его, когда за все окно смен не было.
+// pending - смена состояния на текущей проверке, которая еще не сохранена.
+// Возвращает, идет ли флаппинг сейчас и закончился ли он на этой проверке.
+func (m *AlertManager) checkFlapping(now time.Time, p *Proxy, pending int, stg *Settings) (flapping, settled bool) {
+	threshold, window := flapLimits(p, stg)
+
+	changes, err := m.stateChanges(p.Id, now.Add(-window))
+	if err != nil {
+		return false, false
+	}
+	changes += pending
+
+	alert, err := m.find(p.Id, AlertFlapping)
+	if err != nil {
+		return false, false
+	}
+	if !stg.FlapDetection {
+		m.resolve(now, alert)
+		return false, false
+	}
+
+	if changes >= threshold {
+		if alert == nil {
+			var notify func()
+			if stg.NotifyOnDown || stg.NotifyOnRecovery {
+				notify = func() { m.notifier.NotifyProxyFlapping(p, changes, int(window.Minutes())) }
+			}
+			m.raise(now, nil, p.Id, AlertFlapping, "", 0, stg, notify)
+		}
+		return true, false
+	}
+	if alert == nil {
+		return false, false
+	}
+	if changes > 0 {
+		return true, false
+	}
+	m.resolve(now, alert)
+	return false, true
+}
+
+// stateChanges считает смены состояния up/down прокси начиная с since:
+// открытие алерта proxy_down - падение, его закрытие - восстановление.
+func (m *AlertManager) stateChanges(proxyID string, since time.Time) (int, error) {
+	var alerts []Alert
+	err := m.db.Select("opened_at", "resolved_at").
+		Where("proxy_id = ? AND type = ? AND (opened_at >= ? OR resolved_at >= ?)", proxyID, AlertProxyDown, since, since).
+		Find(&alerts).Error
+	if err != nil {
+		log.Printf("Alerts: failed to load state changes of proxy %s: %v", proxyID, err)
+		return 0, err
+	}
+	changes := 0
+	for _, a := range alerts {
+		if !a.OpenedAt.Before(since) {
+			changes++
+		}
+		if !a.ResolvedAt.IsZero() && !a.ResolvedAt.Before(since) {
+			changes++
+		}
+	}
+	return changes, nil
+}
+
+// flapLimits возвращает порог и окно детектора флаппинга с учетом значений по умолчанию.
+// Один цикл down/up занимает не меньше deadFailureThreshold+1 проверок, поэтому
+// окно растягивается до threshold таких циклов при интервале проверки прокси;
+// FlapWindowMinutes - нижняя граница.
+func flapLimits(p *Proxy, stg *Settings) (threshold int, window time.Duration) {
+	threshold = stg.FlapThreshold
+	if threshold <= 1 {
+		threshold = defaultFlapThreshold
+	}
+	minutes := stg.FlapWindowMinutes
+	if minutes <= 0 {
+		minutes = defaultFlapWindowMinutes
+	}
+	window = time.Duration(minutes) * time.Minute
+
+	cycle := time.Duration(deadFailureThreshold+1) * maxJittered(checkInterval(p, stg), stg)
+	return threshold, max(window, time.Duration(threshold)*cycle)
+}
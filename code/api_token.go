@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Права API-токенов
+const (
+	ScopeVisitsWrite   = "visits:write"   // POST /api/proxyVisits (агент логов)
+	ScopeProxiesRead   = "proxies:read"   // список прокси и их доступность
+	ScopeProxiesVerify = "proxies:verify" // ручная проверка прокси
+)
+
+var tokenScopes = []string{ScopeVisitsWrite, ScopeProxiesRead, ScopeProxiesVerify}
+
+const (
+	apiTokenPrefix = "pct_"
+	// last_used_at пишется не чаще раза в минуту, чтобы агент не писал в базу на каждый запрос
+	tokenTouchInterval = time.Minute
+)
+
+var ErrInvalidToken = errors.New("invalid or expired API token")
+
+// APIToken - токен для скриптов и агента. Передается в заголовке
+// "Authorization: Bearer <token>", в базе хранится только SHA-256.
+type APIToken struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-" gorm:"uniqueIndex"`
+	Hint       string     `json:"hint"` // начало токена, чтобы отличать токены в списке
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"` // nil - бессрочный
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIP string     `json:"lastUsedIp"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+func validScope(scope string) bool {
+	for _, s := range tokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope проверяет, что у токена есть хотя бы одно из прав.
+func (t *APIToken) HasScope(scopes ...string) bool {
+	for _, have := range t.Scopes {
+		for _, want := range scopes {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Active - токен не отозван и не истек.
+func (t *APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+func (t *APIToken) Get(db *gorm.DB, id string) error {
+	return db.First(t, "id = ?", id).Error
+}
+
+// ListAPITokens возвращает токены, новые первыми.
+func ListAPITokens(db *gorm.DB) ([]APIToken, error) {
+	tokens := []APIToken{}
+	err := db.Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// CreateAPIToken создает токен и возвращает его значение. Значение
+// показывается один раз - восстановить его из базы нельзя.
+func CreateAPIToken(db *gorm.DB, t *APIToken) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+	t.ID = uuid.NewString()
+	t.Hash = hashToken(token)
+	t.Hint = token[:len(apiTokenPrefix)+6]
+	t.CreatedAt = time.Now()
+	if err := db.Create(t).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeAPIToken отзывает токен; запись остается для истории.
+func RevokeAPIToken(db *gorm.DB, t *APIToken) error {
+	now := time.Now()
+	t.RevokedAt = &now
+	return db.Model(t).Update("revoked_at", now).Error
+}
+
+// TokenFromBearer находит действующий токен и отмечает его использование.
+func TokenFromBearer(db *gorm.DB, token, ip string) (*APIToken, error) {
+	var tokens []APIToken
+	if err := db.Where("hash = ?", hashToken(token)).Limit(1).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if len(tokens) == 0 || !tokens[0].Active(now) {
+		return nil, ErrInvalidToken
+	}
+	t := &tokens[0]
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= tokenTouchInterval || t.LastUsedIP != ip {
+		t.LastUsedAt, t.LastUsedIP = &now, ip
+		if err := db.Model(t).Updates(map[string]any{"last_used_at": now, "last_used_ip": ip}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Действия журнала аудита
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditImport = "import"
+	AuditExport = "export"
+	AuditVerify = "verify"
+	AuditLogin  = "login"
+	AuditRevoke = "revoke"
+	AuditReload = "reload"
+	AuditTest   = "test"
+	AuditSend   = "send"
+	AuditReveal = "reveal"
+)
+
+// Сущности журнала аудита
+const (
+	AuditProxy       = "proxy"
+	AuditSettings    = "settings"
+	AuditUser        = "user"
+	AuditToken       = "api_token"
+	AuditTagSchedule = "tag_schedule"
+	AuditGeoIP       = "geoip"
+	AuditNotifier    = "notification"
+	AuditReport      = "report"
+)
+
+// Ключи JSON, значения которых не попадают в журнал
+var auditSecretKeys = map[string]bool{
+	"password":          true,
+	"secret":            true,
+	"telegramToken":     true,
+	"slackWebhookUrl":   true,
+	"discordWebhookUrl": true,
+	"smtpPassword":      true,
+}
+
+const auditRedacted = "[redacted]"
+
+// AuditEvent - запись о действии пользователя или API-токена, изменившем данные.
+// Фоновые проверки планировщика и прием логов посещений (proxyVisits) не
+// пишутся: это поток телеметрии, а не действия людей.
+type AuditEvent struct {
+	ID         string                 `json:"id" gorm:"primaryKey"`
+	Time       time.Time              `json:"time" gorm:"index"`
+	Actor      string                 `json:"actor" gorm:"index"` // имя пользователя или "token:<имя>"
+	Action     string                 `json:"action" gorm:"index"`
+	EntityType string                 `json:"entity_type" gorm:"index"`
+	EntityID   string                 `json:"entity_id" gorm:"index"`
+	EntityName string                 `json:"entity_name"`
+	Changes    map[string]AuditChange `json:"changes" gorm:"serializer:json"` // секреты скрыты
+	Details    string                 `json:"details"`
+	SourceIP   string                 `json:"source_ip"`
+	Success    bool                   `json:"success"`
+}
+
+// AuditChange - значение поля до и после действия.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditFilters - фильтры журнала аудита. Период - [StartDate, EndDate).
+type AuditFilters struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	StartDate  time.Time
+	EndDate    time.Time
+	Page       int
+	PageSize   int
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// List возвращает события с пагинацией, новые первыми.
+func (e *AuditEvent) List(filters AuditFilters, db *gorm.DB) ([]AuditEvent, int64, error) {
+	events := []AuditEvent{}
+	query := db.Model(e)
+	if filters.Actor != "" {
+		query = query.Where("actor = ?", filters.Actor)
+	}
+	if filters.Action != "" {
+		query = query.Where("action = ?", filters.Action)
+	}
+	if filters.EntityType != "" {
+		query = query.Where("entity_type = ?", filters.EntityType)
+	}
+	if filters.EntityID != "" {
+		query = query.Where("entity_id = ?", filters.EntityID)
+	}
+	// time - ключевое слово в PostgreSQL, колонку указываем с таблицей
+	if !filters.StartDate.IsZero() {
+		query = query.Where("audit_events.time >= ?", filters.StartDate)
+	}
+	if !filters.EndDate.IsZero() {
+		query = query.Where("audit_events.time < ?", filters.EndDate)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return events, 0, err
+	}
+
+	offset := 0
+	if filters.Page > 1 {
+		offset = filters.PageSize * (filters.Page - 1)
+	}
+	err := query.Order("audit_events.time desc").Limit(filters.PageSize).Offset(offset).Find(&events).Error
+	return events, count, err
+}
+
+// auditEntry - данные одного события для записи из обработчика.
+type auditEntry struct {
+	Action     string
+	EntityType string
+	EntityID   string
+	EntityName string
+	Before     any // nil при создании
+	After      any // nil при удалении
+	Details    string
+	Failed     bool
+}
+
+// audit записывает событие от имени пользователя или токена запроса.
+// Ошибка записи журнала не прерывает запрос и только пишется в лог.
+func (h handler) audit(c *gin.Context, entry auditEntry) {
+	actor := ""
+	if user := currentUser(c); user != nil {
+		actor = user.Username
+	} else if token := currentToken(c); token != nil {
+		actor = "token:" + token.Name
+	}
+	h.auditAs(c, actor, entry)
+}
+
+func (h handler) auditAs(c *gin.Context, actor string, entry auditEntry) {
+	event := AuditEvent{
+		ID:         uuid.NewString(),
+		Time:       time.Now(),
+		Actor:      actor,
+		Action:     entry.Action,
+		EntityType: entry.EntityType,
+		EntityID:   entry.EntityID,
+		EntityName: entry.EntityName,
+		Changes:    auditDiff(entry.Before, entry.After),
+		Details:    entry.Details,
+		SourceIP:   c.ClientIP(),
+		Success:    !entry.Failed,
+	}
+	if err := h.db.Create(&event).Error; err != nil {
+		log.Printf("Audit: failed to record %s %s %s by %s: %v", event.Action, event.EntityType, event.EntityID, actor, err)
+	}
+}
+
+// auditDiff сравнивает JSON-представления before и after по полям верхнего
+// уровня и возвращает изменившиеся. Значения секретов заменяются на
+// "[redacted]", но сам факт их изменения виден.
+func auditDiff(before, after any) map[string]AuditChange {
+	b, a := auditFields(before), auditFields(after)
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make(map[string]AuditChange)
+	for _, k := range keys {
+		bv, av := b[k], a[k]
+		if reflect.DeepEqual(bv, av) || (isEmptyJSON(bv) && isEmptyJSON(av)) {
+			continue
+		}
+		changes[k] = AuditChange{Before: redactAudit(k, bv), After: redactAudit(k, av)}
+	}
+	return changes
+}
+
+func auditFields(v any) map[string]any {
+	fields := map[string]any{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Audit: failed to marshal %T: %v", v, err)
+		return fields
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// Не объект - пишем значение целиком
+		var value any
+		json.Unmarshal(data, &value)
+		return map[string]any{"value": value}
+	}
+	return fields
+}
+
+// redactAudit скрывает секреты в значении поля key, в том числе во вложенных объектах.
+func redactAudit(key string, v any) any {
+	if auditSecretKeys[key] {
+		if isEmptyJSON(v) {
+			return v
+		}
+		return auditRedacted
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, item := range t {
+			out[k] = redactAudit(k, item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			out[i] = redactAudit("", item)
+		}
+		return out
+	}
+	return v
+}
+
+func isEmptyJSON(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case float64:
+		return t == 0
+	case bool:
+		return !t
+	case []any:
+		return len(t) == 0
+	case map[string]any:
+		return len(t) == 0
+	}
+	return false
+}
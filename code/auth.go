@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionCookie   = "pc_session"
+	contextUserKey  = "user"
+	contextTokenKey = "apiToken"
+)
+
+// AuthRequired пропускает запросы с API-токеном в заголовке
+// "Authorization: Bearer", с действующей сессией (cookie) или с HTTP Basic
+// именем и паролем пользователя - для скриптов и curl.
+func AuthRequired(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token, err := TokenFromBearer(db, strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), c.ClientIP())
+			if err != nil {
+				if err != ErrInvalidToken {
+					log.Println("Error checking API token:", err)
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidToken.Error()})
+				return
+			}
+			c.Set(contextTokenKey, token)
+			c.Next()
+			return
+		}
+
+		var user *User
+		if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+			user, _ = SessionUser(db, token)
+		}
+		if user == nil {
+			if username, password, ok := c.Request.BasicAuth(); ok {
+				if wait := loginLimiter.Blocked(c.ClientIP(), username); wait > 0 {
+					retryAfter(c, wait)
+					return
+				}
+				var err error
+				user, err = Authenticate(db, username, password)
+				switch {
+				case err == nil:
+					loginLimiter.Succeeded(c.ClientIP(), username)
+				case err == ErrInvalidCredentials:
+					log.Printf("Auth: failed basic auth for %q from %s", username, c.ClientIP())
+					loginLimiter.Failed(c.ClientIP(), username)
+				default:
+					log.Println("Error authenticating user:", err)
+				}
+			}
+		}
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Set(contextUserKey, user)
+		c.Next()
+	}
+}
+
+// RequireRole пропускает пользователей с ролью не ниже role и API-токены
+// с одним из прав scopes. Без scopes токены не допускаются.
+func RequireRole(role string, scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := currentToken(c); token != nil {
+			if len(scopes) == 0 || !token.HasScope(scopes...) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API token lacks the required scope"})
+				return
+			}
+			c.Next()
+			return
+		}
+		user := currentUser(c)
+		if user == nil || !user.HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions, " + role + " role required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUser возвращает пользователя, установленного AuthRequired.
+func currentUser(c *gin.Context) *User {
+	if v, ok := c.Get(contextUserKey); ok {
+		if user, ok := v.(*User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentToken возвращает API-токен запроса, установленный AuthRequired.
+func currentToken(c *gin.Context) *APIToken {
+	if v, ok := c.Get(contextTokenKey); ok {
+		if token, ok := v.(*APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// revealSecrets проверяет запрос ?reveal=true: секреты в открытом виде
+// получают только пользователи с ролью не ниже role. При отказе пишет 403
+// и возвращает ok=false.
+func revealSecrets(c *gin.Context, role string) (reveal, ok bool) {
+	if c.Query("reveal") != "true" {
+		return false, true
+	}
+	if user := currentUser(c); user == nil || !user.HasRole(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Revealing secrets requires the " + role + " role"})
+		return false, false
+	}
+	return true, true
+}
+
+// setSessionCookie ставит cookie сессии; пустой token удаляет ее.
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSLAWindowDays = 30
+	slaCheckInterval     = 15 * time.Minute
+	maxAvailabilityDays  = 366
+)
+
+// Availability - доступность прокси за период, посчитанная по инцидентам.
+// Время до первой проверки прокси в период не входит.
+type Availability struct {
+	ProxyID      string    `json:"proxy_id"`
+	Name         string    `json:"name"`
+	Tag          string    `json:"tag"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Monitored    int64     `json:"monitored"` // секунды под наблюдением
+	Downtime     int64     `json:"downtime"`  // секунды
+	Incidents    int       `json:"incidents"`
+	Availability float64   `json:"availability"` // процент
+	SLATarget    float64   `json:"sla_target"`   // 0 - цель не задана
+	Breach       bool      `json:"breach"`
+}
+
+// DailyAvailability - точка дневного ряда доступности.
+type DailyAvailability struct {
+	Date         string  `json:"date"` // YYYY-MM-DD
+	Downtime     int64   `json:"downtime"`
+	Availability float64 `json:"availability"`
+	Monitored    bool    `json:"monitored"` // false - прокси еще не проверялся
+}
+
+// parseWindow разбирает период: window вида "24h", "7d", "30d" отсчитывается
+// от now, start/end - даты YYYY-MM-DD (end включительно) или RFC3339.
+func parseWindow(window, start, end string, now time.Time) (from, to time.Time, err error) {
+	if start != "" || end != "" {
+		to = now
+		if start == "" {
+			return from, to, fmt.Errorf("start is required with end")
+		}
+		if from, err = parseWindowTime(start, false); err != nil {
+			return from, to, err
+		}
+		if end != "" {
+			if to, err = parseWindowTime(end, true); err != nil {
+				return from, to, err
+			}
+		}
+		if !from.Before(to) {
+			return from, to, fmt.Errorf("start must be before end")
+		}
+		return from, to, nil
+	}
+
+	if window == "" {
+		window = "24h"
+	}
+	unit := window[len(window)-1]
+	n, err := strconv.Atoi(window[:len(window)-1])
+	if err != nil || n <= 0 || (unit != 'h' && unit != 'd') {
+		return from, to, fmt.Errorf("invalid window %q, expected e.g. 24h, 7d, 30d", window)
+	}
+	if unit == 'd' {
+		if n > maxAvailabilityDays {
+			return from, to, fmt.Errorf("window must not exceed %d days", maxAvailabilityDays)
+		}
+		return now.AddDate(0, 0, -n), now, nil
+	}
+	return now.Add(-time.Duration(n) * time.Hour), now, nil
+}
+
+// parseWindowTime разбирает дату YYYY-MM-DD (конец дня для endOfDay) или RFC3339.
+func parseWindowTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC3339", value)
+	}
+	return t, nil
+}
+
+// ProxyAvailability считает доступность прокси за период [from, to).
+func ProxyAvailability(db *gorm.DB, proxies []Proxy, from, to time.Time) ([]Availability, error) {
+	from, to = from.In(time.Local), to.In(time.Local)
+	ids := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		ids = append(ids, p.Id)
+	}
+
+	incidents, err := incidentsByProxy(db, ids, from, to)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := monitoringStarts(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tagSLATargets(db)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]Availability, 0, len(proxies))
+	for i := range proxies {
+		p := &proxies[i]
+		a := Availability{
+			ProxyID:   p.Id,
+			Name:      proxyDisplayName(p),
+			Tag:       p.Tag,
+			From:      from,
+			To:        to,
+			SLATarget: slaTarget(p, targets),
+		}
+		begin := from
+		if s, ok := starts[p.Id]; !ok || s.After(begin) {
+			begin = s
+		}
+		if begin.IsZero() || !begin.Before(to) {
+			result = append(result, a) // прокси в этот период не проверялся
+			continue
+		}
+
+		a.Monitored = int64(to.Sub(begin).Seconds())
+		a.Downtime, a.Incidents = downtime(incidents[p.Id], begin, to, now)
+		a.Availability = 100 - percent(int(a.Downtime), int(a.Monitored))
+		a.Breach = a.SLATarget > 0 && a.Availability < a.SLATarget
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+// ProxyDailyAvailability возвращает доступность прокси по дням за последние days
+// дней (включая сегодняшний) в часовом поясе loc.
+func ProxyDailyAvailability(db *gorm.DB, p *Proxy, days int, loc *time.Location) ([]DailyAvailability, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, -(days - 1))
+
+	incidents, err := incidentsByProxy(db, []string{p.Id}, from, now)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := monitoringStarts(db, []string{p.Id})
+	if err != nil {
+		return nil, err
+	}
+	start, known := starts[p.Id]
+
+	series := make([]DailyAvailability, 0, days)
+	for day := from; day.Before(now); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1)
+		if end.After(now) {
+			end = now
+		}
+		point := DailyAvailability{Date: day.Format("2006-01-02")}
+		begin := day
+		if known && start.After(begin) {
+			begin = start
+		}
+		if known && begin.Before(end) {
+			point.Monitored = true
+			point.Downtime, _ = downtime(incidents[p.Id], begin, end, now)
+			point.Availability = 100 - percent(int(point.Downtime), int(end.Sub(begin).Seconds()))
+		}
+		series = append(series, point)
+	}
+	return series, nil
+}
+
+// downtime суммирует пересечение инцидентов с периодом [from, to).
+// Открытый инцидент длится по now.
+func downtime(incidents []Incident, from, to, now time.Time) (seconds int64, count int) {
+	for _, i := range incidents {
+		end := i.EndedAt
+		if i.Status == IncidentOpen {
+			end = now
+		}
+		start := i.StartedAt
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			seconds += int64(end.Sub(start).Seconds())
+			count++
+		}
+	}
+	return seconds, count
+}
+
+// incidentsByProxy загружает инциденты, пересекающиеся с периодом.
+func incidentsByProxy(db *gorm.DB, ids []string, from, to time.Time) (map[string][]Incident, error) {
+	var incidents []Incident
+	err := db.Where("proxy_id IN ? AND started_at < ? AND (status = ? OR ended_at > ?)", ids, to, IncidentOpen, from).
+		Find(&incidents).Error
+	if err != nil {
+		return nil, err
+	}
+	byProxy := make(map[string][]Incident)
+	for _, i := range incidents {
+		byProxy[i.ProxyID] = append(byProxy[i.ProxyID], i)
+	}
+	return byProxy, nil
+}
+
+// monitoringStarts возвращает время первой проверки каждого прокси: первую
+// запись истории IP или первую ошибку, если прокси ни разу не отвечал.
+func monitoringStarts(db *gorm.DB, ids []string) (map[string]time.Time, error) {
+	starts := make(map[string]time.Time)
+	for _, model := range []any{&ProxyIPLog{}, &ProxyFailureLog{}} {
+		var rows []struct {
+			ProxyID string
+			First   string
+		}
+		err := db.Model(model).
+			Select("proxy_id, MIN(timestamp) AS first").
+			Where("proxy_id IN ?", ids).
+			Group("proxy_id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			first, err := parseDBTime(r.First)
+			if err != nil {
+				log.Printf("Availability: unexpected timestamp %q for proxy %s: %v", r.First, r.ProxyID, err)
+				continue
+			}
+			if s, ok := starts[r.ProxyID]; !ok || first.Before(s) {
+				starts[r.ProxyID] = first
+			}
+		}
+	}
+	return starts, nil
+}
+
+// parseDBTime разбирает время, возвращенное агрегатной функцией как строка:
+// SQLite отдает сохраненный текст, а time.Time из PostgreSQL database/sql
+// переводит в RFC3339Nano при чтении в string.
+func parseDBTime(value string) (time.Time, error) {
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02T15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		time.RFC3339Nano,
+	} {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format")
+}
+
+// slaTarget возвращает цель SLA прокси: свою или тега.
+func slaTarget(p *Proxy, tagTargets map[string]float64) float64 {
+	if p.SLATarget > 0 {
+		return p.SLATarget
+	}
+	return tagTargets[p.Tag]
+}
+
+// validateSLATarget проверяет цель SLA в процентах (0 - не задана).
+func validateSLATarget(target float64) error {
+	if target < 0 || target > 100 {
+		return fmt.Errorf("slaTarget must be between 0 and 100")
+	}
+	return nil
+}
+
+// slaWindow - период, за который проверяется SLA.
+func slaWindow(stg *Settings) time.Duration {
+	days := stg.SLAWindowDays
+	if days <= 0 {
+		days = defaultSLAWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// runSLACheck сверяет доступность прокси с целями SLA и открывает или
+// закрывает алерты о нарушении.
+func (m *SchedulerManager) runSLACheck(ctx context.Context, stg *Settings) {
+	var proxies []Proxy
+	if err := m.db.Find(&proxies).Error; err != nil {
+		log.Println("Scheduler: Error fetching proxies for SLA check:", err)
+		return
+	}
+	now := time.Now()
+	list, err := ProxyAvailability(m.db, proxies, now.Add(-slaWindow(stg)), now)
+	if err != nil {
+		log.Println("Scheduler: Error computing availability for SLA check:", err)
+		return
+	}
+
+	breaches := 0
+	for i, a := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		if a.Breach {
+			breaches++
+		}
+		m.alerts.SLABreach(&proxies[i], a, stg)
+	}
+	if breaches > 0 {
+		log.Printf("Scheduler: SLA check found %d proxies below target.", breaches)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Порог, после которого прокси считается мертвым (LastStatus = 2)
+const deadFailureThreshold = 3
+
+// Значения backoff по умолчанию
+const (
+	defaultBackoffBaseMinutes = 5
+	defaultBackoffMaxMinutes  = 360
+	defaultBackoffMultiplier  = 2.0
+)
+
+// inBackoff сообщает, проверяется ли прокси по расписанию backoff.
+func inBackoff(p *Proxy, stg *Settings) bool {
+	return stg.BackoffEnabled && p.LastStatus == 2
+}
+
+// backoffInterval возвращает интервал проверки мертвого прокси:
+// base * multiplier^n, где n - число неудачных проверок после пометки мертвым,
+// но не больше BackoffMax. Живые прокси проверяются с обычным интервалом,
+// поэтому после восстановления (Failures = 0) расписание сбрасывается само.
+func backoffInterval(p *Proxy, stg *Settings, interval time.Duration) time.Duration {
+	if !inBackoff(p, stg) || interval <= 0 {
+		return interval
+	}
+
+	base := time.Duration(stg.BackoffBase) * time.Minute
+	if base <= 0 {
+		base = interval
+	}
+	maxInterval := time.Duration(stg.BackoffMax) * time.Minute
+	if maxInterval < base {
+		maxInterval = base
+	}
+	multiplier := stg.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	n := p.Failures - deadFailureThreshold
+	if n < 0 {
+		n = 0
+	}
+	next := float64(base) * math.Pow(multiplier, float64(n))
+	if next >= float64(maxInterval) {
+		return maxInterval
+	}
+	return time.Duration(next)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Как часто очередь сверяется с базой (новые/удаленные прокси, интервалы тегов)
+	checkQueueSyncInterval = 30 * time.Second
+	// Jitter по умолчанию, если в настройках не задан, в процентах от интервала
+	defaultCheckJitterPercent = 10
+	maxCheckJitterPercent     = 50
+)
+
+// dueItem - прокси в очереди проверок со временем следующей проверки.
+type dueItem struct {
+	proxyID  string
+	due      time.Time
+	interval time.Duration
+	index    int
+}
+
+// dueHeap - min-heap по времени следующей проверки (container/heap).
+type dueHeap []*dueItem
+
+func (h dueHeap) Len() int           { return len(h) }
+func (h dueHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h dueHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *dueHeap) Push(x any) {
+	item := x.(*dueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *dueHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// CheckQueue планирует проверки IP по времени: у каждого прокси свой интервал
+// (прокси -> тег -> глобальный CheckIPInterval) и случайный jitter, поэтому
+// проверки распределяются равномерно, а не запускаются все разом.
+type CheckQueue struct {
+	db       *gorm.DB
+	geoIP    *GeoIPClient
+	alerts   *AlertManager
+	guard    *ConnectivityGuard
+	settings func() *Settings
+
+	mu       sync.Mutex
+	heap     dueHeap
+	items    map[string]*dueItem // прокси в очереди
+	inFlight map[string]bool     // прокси, которые сейчас проверяются
+	tags     map[string]int
+
+	// Статистика окна между синхронизациями для адаптивного числа воркеров
+	stats       *jobStatsStore
+	adaptive    adaptiveWorkers
+	windowStart time.Time
+	busy        time.Duration // суммарное время проверок в окне
+	checked     int
+
+	wake    chan struct{} // перечитать прокси и настройки
+	resched chan struct{} // изменилось начало очереди, пересчитать таймер
+	work    chan string
+	pool    workerPool
+}
+
+func NewCheckQueue(db *gorm.DB, geoIP *GeoIPClient, alerts *AlertManager, guard *ConnectivityGuard, settings func() *Settings, stats *jobStatsStore) *CheckQueue {
+	return &CheckQueue{
+		db:       db,
+		geoIP:    geoIP,
+		alerts:   alerts,
+		guard:    guard,
+		settings: settings,
+		stats:    stats,
+		items:    make(map[string]*dueItem),
+		inFlight: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+		resched:  make(chan struct{}, 1),
+		work:     make(chan string),
+	}
+}
+
+// Wake заставляет очередь немедленно перечитать прокси и настройки.
+func (q *CheckQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunNow ставит все прокси в очередь на немедленную проверку.
+func (q *CheckQueue) RunNow() {
+	q.mu.Lock()
+	now := time.Now()
+	for _, item := range q.items {
+		item.due = now
+	}
+	heap.Init(&q.heap)
+	q.mu.Unlock()
+
+	q.Wake()
+}
+
+func (q *CheckQueue) kick() {
+	select {
+	case q.resched <- struct{}{}:
+	default:
+	}
+}
+
+// Run - цикл диспетчера. Выдает воркерам прокси, время проверки которых наступило.
+func (q *CheckQueue) Run(wg *sync.WaitGroup, quit <-chan struct{}) {
+	defer wg.Done()
+
+	q.pool.start(q.work, q.check)
+	defer q.pool.stop()
+
+	q.sync()
+
+	syncTicker := time.NewTicker(checkQueueSyncInterval)
+	defer syncTicker.Stop()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	// Пока у чекера нет связи, проверки откладываются
+	var pausedUntil time.Time
+
+	for {
+		wait := q.untilNext()
+		if d := time.Until(pausedUntil); d > wait {
+			wait = d
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+			if !q.guard.Check(q.settings()) {
+				pausedUntil = time.Now().Add(canaryCheckInterval)
+				continue
+			}
+			ids := q.popDue(time.Now())
+			for i, id := range ids {
+				select {
+				case q.work <- id:
+				case <-quit:
+					q.shutdown(len(ids) - i)
+					return
+				}
+			}
+		case <-syncTicker.C:
+			q.sync()
+		case <-q.wake:
+			q.sync()
+		case <-q.resched:
+		case <-quit:
+			q.shutdown(0)
+			return
+		}
+	}
+}
+
+// shutdown учитывает прокси, которые уже пора было проверить, но проверка
+// не началась из-за остановки. undispatched - извлеченные, но не выданные воркерам.
+func (q *CheckQueue) shutdown(undispatched int) {
+	q.mu.Lock()
+	skipped := undispatched
+	now := time.Now()
+	for _, item := range q.heap {
+		if !item.due.After(now) {
+			skipped++
+		}
+	}
+	q.mu.Unlock()
+
+	if skipped > 0 {
+		log.Printf("Scheduler: IP check queue stopped, %d due proxies skipped.", skipped)
+	}
+	stats := q.stats.get(JobIPCheck)
+	stats.Skipped = skipped
+	q.stats.record(stats)
+	log.Println("Scheduler: Shutting down IP check queue.")
+}
+
+// Stats возвращает статистику очереди для API.
+func (q *CheckQueue) Stats() JobStats {
+	stats := q.stats.get(JobIPCheck)
+	q.mu.Lock()
+	stats.Queued = len(q.heap)
+	stats.InFlight = len(q.inFlight)
+	q.mu.Unlock()
+	return stats
+}
+
+// untilNext возвращает время до ближайшей проверки.
+func (q *CheckQueue) untilNext() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.heap) == 0 {
+		return checkQueueSyncInterval
+	}
+	d := time.Until(q.heap[0].due)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// popDue извлекает из очереди все прокси, время проверки которых наступило.
+func (q *CheckQueue) popDue(now time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	for len(q.heap) > 0 && !q.heap[0].due.After(now) {
+		item := heap.Pop(&q.heap).(*dueItem)
+		delete(q.items, item.proxyID)
+		q.inFlight[item.proxyID] = true
+		ids = append(ids, item.proxyID)
+	}
+	return ids
+}
+
+// sync сверяет очередь с базой: добавляет новые прокси, убирает удаленные,
+// пересчитывает интервалы и применяет число воркеров из настроек.
+func (q *CheckQueue) sync() {
+	stg := q.settings()
+	q.pool.resize(q.nextWorkers(stg))
+
+	var proxies []Proxy
+	if err := q.db.Find(&proxies).Error; err != nil {
+		log.Println("Scheduler: Error fetching proxies for IP check queue:", err)
+		return
+	}
+	tags, err := tagIntervals(q.db)
+	if err != nil {
+		log.Println("Scheduler: Error fetching tag schedules:", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tags = tags
+	now := time.Now()
+	present := make(map[string]bool, len(proxies))
+
+	for i := range proxies {
+		p := &proxies[i]
+		present[p.Id] = true
+		if q.inFlight[p.Id] {
+			continue // будет перепланирован после проверки
+		}
+
+		interval := q.intervalFor(p, stg)
+		item, queued := q.items[p.Id]
+
+		switch {
+		case interval <= 0:
+			if queued {
+				heap.Remove(&q.heap, item.index)
+				delete(q.items, p.Id)
+			}
+		case !queued:
+			// Новый прокси: никогда не проверенные - сразу, остальные
+			// раскладываем равномерно по интервалу, чтобы не было всплеска.
+			due := now
+			if !p.LastCheck.IsZero() {
+				due = now.Add(time.Duration(rand.Int64N(int64(interval))))
+			}
+			item = &dueItem{proxyID: p.Id, due: due, interval: interval}
+			heap.Push(&q.heap, item)
+			q.items[p.Id] = item
+		case item.interval != interval:
+			// Интервал изменился: не ждем дольше нового интервала
+			if next := now.Add(jittered(interval, stg)); next.Before(item.due) {
+				item.due = next
+			}
+			item.interval = interval
+			heap.Fix(&q.heap, item.index)
+		}
+	}
+
+	for id, item := range q.items {
+		if !present[id] {
+			heap.Remove(&q.heap, item.index)
+			delete(q.items, id)
+		}
+	}
+}
+
+// nextWorkers закрывает окно статистики и возвращает число воркеров.
+// Нагрузка окна - доля времени, которую воркеры были заняты; если проверки
+// запаздывают больше чем на окно синхронизации, воркеров не хватает.
+func (q *CheckQueue) nextWorkers(stg *Settings) int {
+	now := time.Now()
+	workers := q.pool.current()
+
+	q.mu.Lock()
+	elapsed := now.Sub(q.windowStart)
+	load := 0.0
+	if workers > 0 && elapsed > 0 && !q.windowStart.IsZero() {
+		load = float64(q.busy) / (float64(workers) * float64(elapsed))
+	}
+	if len(q.heap) > 0 && now.Sub(q.heap[0].due) > checkQueueSyncInterval {
+		load = 1
+	}
+	stats := JobStats{
+		Job:          JobIPCheck,
+		Workers:      workers,
+		LastStart:    q.windowStart,
+		LastDuration: elapsed,
+		Checked:      q.checked,
+	}
+	q.windowStart, q.busy, q.checked = now, 0, 0
+	q.mu.Unlock()
+
+	if !stats.LastStart.IsZero() {
+		q.stats.record(stats)
+	}
+	return q.adaptive.next(stg, JobIPCheck, load)
+}
+
+// intervalFor возвращает интервал проверки прокси: свой, тега или глобальный,
+// а для мертвых прокси - увеличенный по backoff. Вызывается под q.mu.
+func (q *CheckQueue) intervalFor(p *Proxy, stg *Settings) time.Duration {
+	minutes := stg.CheckIPInterval
+	if m, ok := q.tags[p.Tag]; ok && p.Tag != "" && m > 0 {
+		minutes = m
+	}
+	if p.CheckInterval > 0 {
+		minutes = p.CheckInterval
+	}
+	return backoffInterval(p, stg, time.Duration(minutes)*time.Minute)
+}
+
+// check проверяет один прокси и ставит его обратно в очередь.
+func (q *CheckQueue) check(id string) {
+	stg := q.settings()
+
+	var p Proxy
+	if err := p.Get(q.db, id); err != nil {
+		// Прокси удален, пока ждал проверки
+		q.mu.Lock()
+		delete(q.inFlight, id)
+		q.mu.Unlock()
+		return
+	}
+
+	start := time.Now()
+	checkSingleProxyIPWithNotifications(&p, stg, q.db, q.geoIP, q.alerts, q.guard)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.busy += time.Since(start)
+	q.checked++
+	delete(q.inFlight, id)
+	interval := q.intervalFor(&p, stg)
+	if interval <= 0 {
+		return
+	}
+	item := &dueItem{proxyID: id, due: time.Now().Add(jittered(interval, stg)), interval: interval}
+	heap.Push(&q.heap, item)
+	q.items[id] = item
+	if item.index == 0 {
+		q.kick()
+	}
+}
+
+// jittered добавляет к интервалу случайное отклонение ±CheckJitterPercent.
+func jittered(interval time.Duration, stg *Settings) time.Duration {
+	spread := int64(jitterSpread(interval, stg))
+	if spread <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
+
+// checkInterval возвращает интервал проверки IP для окон алертов: больший из
+// собственного интервала прокси и глобального, без backoff и jitter.
+func checkInterval(p *Proxy, stg *Settings) time.Duration {
+	minutes := stg.CheckIPInterval
+	if p.CheckInterval > 0 {
+		minutes = max(minutes, p.CheckInterval)
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// maxJittered возвращает самый длинный интервал, который может дать jittered.
+func maxJittered(interval time.Duration, stg *Settings) time.Duration {
+	return interval + jitterSpread(interval, stg)
+}
+
+// jitterSpread возвращает наибольшее отклонение интервала с учетом ограничений jitter.
+func jitterSpread(interval time.Duration, stg *Settings) time.Duration {
+	percent := stg.CheckJitterPercent
+	if percent < 0 {
+		percent = defaultCheckJitterPercent
+	}
+	if percent > maxCheckJitterPercent {
+		percent = maxCheckJitterPercent
+	}
+	return time.Duration(int64(interval) * int64(percent) / 100)
+}
+
+// workerPool - набор воркеров, размер которого можно менять на лету.
+// При уменьшении лишние воркеры завершаются после своей текущей проверки.
+type workerPool struct {
+	mu     sync.Mutex
+	size   int // желаемое число воркеров
+	active int // запущенные воркеры
+	work   <-chan string
+	fn     func(string)
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (p *workerPool) start(work <-chan string, fn func(string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.work = work
+	p.fn = fn
+	p.quit = make(chan struct{})
+}
+
+// current возвращает число запущенных воркеров.
+func (p *workerPool) current() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = n
+	for ; p.active < p.size; p.active++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// retire завершает воркер, если их больше, чем нужно.
+func (p *workerPool) retire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active > p.size {
+		p.active--
+		return true
+	}
+	return false
+}
+
+func (p *workerPool) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case id := <-p.work:
+			p.fn(id)
+			if p.retire() {
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// stop дожидается завершения текущих проверок и останавливает воркеры.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	close(p.quit)
+	p.size, p.active = 0, 0
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,57 @@
+import { ref } from 'vue'
+import axios from 'axios'
+
+export interface User {
+  id: string
+  username: string
+  role: 'admin' | 'operator' | 'viewer'
+  createdAt: string
+  lastLoginAt: string | null
+}
+
+const roleLevels: Record<string, number> = { viewer: 1, operator: 2, admin: 3 }
+
+// Текущий пользователь, общий для всего приложения
+const user = ref<User | null>(null)
+let loaded = false
+
+export function useAuth() {
+  const fetchUser = async (force = false) => {
+    if (loaded && !force) return user.value
+    try {
+      const response = await axios.get('/api/auth/me')
+      user.value = response.data.data
+    } catch {
+      user.value = null
+    }
+    loaded = true
+    return user.value
+  }
+
+  const login = async (username: string, password: string) => {
+    const response = await axios.post('/api/auth/login', { username, password })
+    user.value = response.data.data
+    loaded = true
+    return user.value
+  }
+
+  const logout = async () => {
+    try {
+      await axios.post('/api/auth/logout')
+    } finally {
+      user.value = null
+      loaded = true
+    }
+  }
+
+  // Сбрасывает пользователя после 401, не обращаясь к API
+  const reset = () => {
+    user.value = null
+    loaded = true
+  }
+
+  const hasRole = (role: string) =>
+    !!user.value && roleLevels[user.value.role] >= roleLevels[role]
+
+  return { user, fetchUser, login, logout, reset, hasRole }
+}
@@ -10,6 +10,21 @@ import { createApp } from 'vue'
 import App from './App.vue'
 import router from './router'
 import VueApexCharts from 'vue3-apexcharts'
+import axios from 'axios'
+import { useAuth } from './composables/useAuth'
+
+// Истекшая сессия - на страницу входа
+axios.interceptors.response.use(
+  (response) => response,
+  (error) => {
+    const route = router.currentRoute.value
+    if (error.response?.status === 401 && !route.meta.public && error.config?.url !== '/api/auth/me') {
+      useAuth().reset()
+      router.push({ path: '/signin', query: { redirect: route.fullPath } })
+    }
+    return Promise.reject(error)
+  }
+)
 
 const app = createApp(App)
 
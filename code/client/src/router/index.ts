@@ -1,4 +1,5 @@
 import { createRouter, createWebHistory } from 'vue-router'
+import { useAuth } from '@/composables/useAuth'
 
 const router = createRouter({
   history: createWebHistory(import.meta.env.BASE_URL),
@@ -20,6 +21,24 @@ const router = createRouter({
       component: () => import('../views/Settings.vue'),
       meta: {
         title: 'Settings',
+        role: 'admin',
+      },
+    },
+    {
+      path: '/users',
+      name: 'Users',
+      component: () => import('../views/Users.vue'),
+      meta: {
+        title: 'Users',
+      },
+    },
+    {
+      path: '/audit',
+      name: 'Audit Log',
+      component: () => import('../views/AuditLog.vue'),
+      meta: {
+        title: 'Audit Log',
+        role: 'admin',
       },
     },
     {
@@ -162,14 +181,7 @@ const router = createRouter({
       component: () => import('../views/Auth/Signin.vue'),
       meta: {
         title: 'Signin',
-      },
-    },
-    {
-      path: '/signup',
-      name: 'Signup',
-      component: () => import('../views/Auth/Signup.vue'),
-      meta: {
-        title: 'Signup',
+        public: true,
       },
     },
   ],
@@ -177,7 +189,22 @@ const router = createRouter({
 
 export default router
 
-router.beforeEach((to, from, next) => {
+router.beforeEach(async (to, from, next) => {
   document.title = `${to.meta.title} | Proxy Checker`
+  if (to.meta.public) {
+    next()
+    return
+  }
+
+  const { fetchUser, hasRole } = useAuth()
+  const user = await fetchUser()
+  if (!user) {
+    next({ path: '/signin', query: { redirect: to.fullPath } })
+    return
+  }
+  if (typeof to.meta.role === 'string' && !hasRole(to.meta.role)) {
+    next('/')
+    return
+  }
   next()
 })
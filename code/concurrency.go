@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Имя лимита одновременных замеров скорости (не отдельная задача планировщика:
+// замеры запускаются и health check'ом, и ручной проверкой из API)
+const JobSpeedTest = "speed_test"
+
+// Верхняя граница адаптивного числа воркеров по умолчанию
+const defaultMaxWorkers = 50
+
+// Пороги нагрузки для адаптивного режима: доля интервала, занятая циклом
+const (
+	adaptiveGrowLoad   = 0.8
+	adaptiveShrinkLoad = 0.3
+)
+
+// jobWorkers возвращает число воркеров для задачи: свое значение задачи,
+// иначе общее Workers, иначе значение по умолчанию.
+func jobWorkers(settings *Settings, job string) int {
+	n := 0
+	switch job {
+	case JobIPCheck:
+		n = settings.IPWorkers
+	case JobHealthCheck:
+		n = settings.HealthWorkers
+	case JobSpeedTest:
+		n = settings.SpeedWorkers
+	}
+	if n > 0 {
+		return n
+	}
+	return checkWorkers(settings)
+}
+
+// maxWorkers - верхняя граница для адаптивного режима.
+func maxWorkers(settings *Settings) int {
+	if settings.MaxWorkers > 0 {
+		return settings.MaxWorkers
+	}
+	return defaultMaxWorkers
+}
+
+// adaptiveWorkers подстраивает число воркеров под нагрузку: если цикл занимает
+// больше 80% интервала (или прокси пропускаются), воркеров становится в 1.5 раза
+// больше, если меньше 30% - в 1.5 раза меньше. Настроенное число - нижняя граница.
+type adaptiveWorkers struct {
+	mu      sync.Mutex
+	current int
+}
+
+// next возвращает число воркеров для следующего цикла.
+// load - отношение длительности цикла к интервалу.
+func (a *adaptiveWorkers) next(settings *Settings, job string, load float64) int {
+	base := jobWorkers(settings, job)
+	if !settings.AdaptiveWorkers {
+		return base
+	}
+	limit := maxWorkers(settings)
+	if limit < base {
+		limit = base
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.current < base {
+		a.current = base
+	}
+	switch {
+	case load > adaptiveGrowLoad:
+		a.current += a.current/2 + 1
+	case load < adaptiveShrinkLoad:
+		a.current -= a.current / 3
+	}
+	if a.current > limit {
+		a.current = limit
+	}
+	if a.current < base {
+		a.current = base
+	}
+	return a.current
+}
+
+// JobStats - результат последнего цикла задачи для API. Для очереди IP-проверок
+// циклом считается окно между синхронизациями с базой.
+type JobStats struct {
+	Job          string        `json:"job"`
+	Workers      int           `json:"workers"`
+	LastStart    time.Time     `json:"last_start"`
+	LastDuration time.Duration `json:"last_duration"`
+	Checked      int           `json:"checked"`       // проверено в последнем цикле
+	Skipped      int           `json:"skipped"`       // пропущено из-за отмены в последнем цикле
+	TotalSkipped int           `json:"total_skipped"` // пропущено с момента запуска
+
+	// Только для очереди IP-проверок
+	Queued   int `json:"queued,omitempty"`
+	InFlight int `json:"in_flight,omitempty"`
+}
+
+// jobStatsStore хранит статистику задач под мьютексом.
+type jobStatsStore struct {
+	mu    sync.Mutex
+	stats map[string]JobStats
+}
+
+func (s *jobStatsStore) record(stats JobStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]JobStats)
+	}
+	stats.TotalSkipped = s.stats[stats.Job].TotalSkipped + stats.Skipped
+	s.stats[stats.Job] = stats
+}
+
+func (s *jobStatsStore) get(job string) JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats, ok := s.stats[job]
+	if !ok {
+		stats.Job = job
+	}
+	return stats
+}
+
+// concurrencyLimit ограничивает число одновременных операций. Лимит передается
+// при каждом захвате, поэтому изменение настроек применяется без перезапуска.
+type concurrencyLimit struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+}
+
+func newConcurrencyLimit() *concurrencyLimit {
+	l := &concurrencyLimit{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *concurrencyLimit) acquire(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *concurrencyLimit) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// speedTests ограничивает число одновременных замеров скорости: они
+// забивают канал чекера, и параллельные замеры искажают друг друга.
+var speedTests = newConcurrencyLimit()
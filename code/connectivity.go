@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Цели по умолчанию для проверки связи самого чекера (напрямую, без прокси)
+var defaultCanaryTargets = []string{
+	"https://www.google.com/generate_204",
+	"https://cloudflare.com/cdn-cgi/trace",
+	"1.1.1.1:443",
+}
+
+const (
+	canaryCheckInterval   = 30 * time.Second // как часто перепроверять связь перед проверками
+	canaryConfirmInterval = 5 * time.Second  // не чаще - при подтверждении ошибки прокси
+	defaultCanaryTimeout  = 5 * time.Second
+)
+
+// CanaryResult - результат одной прямой проверки.
+type CanaryResult struct {
+	Target  string `json:"target"`
+	OK      bool   `json:"ok"`
+	Latency int64  `json:"latency"` // ms
+	Error   string `json:"error,omitempty"`
+}
+
+// CheckerStatus - состояние связи чекера для API.
+type CheckerStatus struct {
+	Enabled   bool           `json:"enabled"`
+	Degraded  bool           `json:"degraded"`
+	Since     time.Time      `json:"since"` // с какого момента текущее состояние
+	LastCheck time.Time      `json:"lastCheck"`
+	Canaries  []CanaryResult `json:"canaries"`
+}
+
+// ConnectivityGuard проверяет, что у самого чекера есть интернет. Пока связи
+// нет (ни одна canary-цель не отвечает), чекер "деградирован": проверки
+// прокси не запускаются, а их ошибки не засчитываются прокси.
+type ConnectivityGuard struct {
+	probe sync.Mutex // одна проверка за раз, остальные ждут ее результата
+
+	mu     sync.RWMutex
+	status CheckerStatus
+}
+
+func NewConnectivityGuard() *ConnectivityGuard {
+	return &ConnectivityGuard{}
+}
+
+// Check возвращает true, если связь есть. Результат кешируется на canaryCheckInterval.
+func (g *ConnectivityGuard) Check(stg *Settings) bool {
+	return g.check(stg, canaryCheckInterval)
+}
+
+// Confirm перепроверяет связь после ошибки прокси, чтобы не списать на прокси
+// пропажу интернета у чекера. Возвращает true, если связь есть.
+func (g *ConnectivityGuard) Confirm(stg *Settings) bool {
+	return g.check(stg, canaryConfirmInterval)
+}
+
+// Status возвращает последнее известное состояние.
+func (g *ConnectivityGuard) Status() CheckerStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	s := g.status
+	s.Canaries = append([]CanaryResult(nil), s.Canaries...)
+	return s
+}
+
+func (g *ConnectivityGuard) check(stg *Settings, maxAge time.Duration) bool {
+	if !stg.CanaryEnabled {
+		g.mu.Lock()
+		g.status.Enabled, g.status.Degraded = false, false
+		g.mu.Unlock()
+		return true
+	}
+
+	g.probe.Lock()
+	defer g.probe.Unlock()
+
+	g.mu.RLock()
+	fresh := g.status.Enabled && time.Since(g.status.LastCheck) < maxAge
+	degraded := g.status.Degraded
+	g.mu.RUnlock()
+	if fresh {
+		return !degraded
+	}
+
+	results := probeCanaries(stg)
+	ok := false
+	for _, r := range results {
+		if r.OK {
+			ok = true
+			break
+		}
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.status.Enabled || g.status.Degraded == ok {
+		g.status.Since = now
+		if !ok {
+			log.Printf("Checker: connectivity lost, all canaries failed - proxy checks paused: %v", canaryErrors(results))
+		} else if g.status.Enabled {
+			log.Println("Checker: connectivity restored, resuming proxy checks")
+		}
+	}
+	g.status.Enabled = true
+	g.status.Degraded = !ok
+	g.status.LastCheck = now
+	g.status.Canaries = results
+	return ok
+}
+
+// probeCanaries опрашивает все цели параллельно.
+func probeCanaries(stg *Settings) []CanaryResult {
+	targets := stg.CanaryTargets
+	if len(targets) == 0 {
+		targets = defaultCanaryTargets
+	}
+	timeout := time.Duration(stg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultCanaryTimeout
+	}
+
+	results := make([]CanaryResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			start := time.Now()
+			err := probeCanary(strings.TrimSpace(target), timeout)
+			results[i] = CanaryResult{Target: target, OK: err == nil, Latency: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// probeCanary проверяет одну цель: URL - HTTP-запросом (любой ответ считается
+// успехом), host:port - TCP-подключением.
+func probeCanary(target string, timeout time.Duration) error {
+	if !strings.Contains(target, "://") {
+		conn, err := net.DialTimeout("tcp", target, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	// Без прокси из окружения: проверяем собственную связь чекера
+	client := &http.Client{Transport: &http.Transport{Proxy: nil, DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func canaryErrors(results []CanaryResult) string {
+	errs := make([]string, 0, len(results))
+	for _, r := range results {
+		errs = append(errs, fmt.Sprintf("%s: %s", r.Target, r.Error))
+	}
+	return strings.Join(errs, "; ")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Поддерживаемые драйверы базы данных
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+const defaultSQLiteDSN = "database/proxy.db"
+
+// Размер пула соединений PostgreSQL. Планировщики и агенты пишут
+// параллельно, держим соединения открытыми, а не открываем на каждый запрос.
+const (
+	postgresMaxOpenConns = 25
+	postgresMaxIdleConns = 10
+)
+
+// databaseModels - таблицы, которые создает AutoMigrate при запуске.
+var databaseModels = []any{
+	&Proxy{}, &Settings{}, &ProxySpeedLog{}, &ProxyIPLog{}, &ProxyVisitLogs{}, &ProxyFailureLog{},
+	&TagSchedule{}, &Alert{}, &Incident{}, &User{}, &Session{}, &APIToken{}, &AuditEvent{}, &ServerLease{},
+}
+
+// dbConfigFromEnv возвращает драйвер и DSN из PROXYCHECKER_DB_DRIVER и
+// PROXYCHECKER_DB_DSN. По умолчанию - SQLite в database/proxy.db.
+func dbConfigFromEnv() (driver, dsn string) {
+	driver = os.Getenv("PROXYCHECKER_DB_DRIVER")
+	if driver == "" {
+		driver = DriverSQLite
+	}
+	dsn = os.Getenv("PROXYCHECKER_DB_DSN")
+	return driver, dsn
+}
+
+// OpenDatabase открывает базу выбранным драйвером. Для PostgreSQL dsn
+// обязателен: "host=... user=... dbname=..." или postgres://...
+func OpenDatabase(driver, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch strings.ToLower(driver) {
+	case DriverSQLite, "sqlite3":
+		if dsn == "" {
+			dsn = defaultSQLiteDSN
+		}
+		dialector = sqlite.Open(dsn)
+	case DriverPostgres, "postgresql", "pgx":
+		if dsn == "" {
+			return nil, fmt.Errorf("database DSN is required for the %s driver", DriverPostgres)
+		}
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, expected %s or %s", driver, DriverSQLite, DriverPostgres)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if dialector.Name() == DriverPostgres {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(postgresMaxOpenConns)
+		sqlDB.SetMaxIdleConns(postgresMaxIdleConns)
+	}
+	return db, nil
+}
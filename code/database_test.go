@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Запросы, которые по-разному ведут себя в SQLite и PostgreSQL, проверяются
+// на обеих базах через OpenDatabase. PostgreSQL берется из
+// PROXYCHECKER_TEST_DB_DRIVER/PROXYCHECKER_TEST_DB_DSN, иначе запускается
+// embedded-postgres (при первом запуске скачивает бинарники). Если PostgreSQL
+// недоступен или задан -short, подтесты postgres пропускаются с причиной.
+
+var (
+	testPostgresDriver = DriverPostgres
+	testPostgresDSN    string
+	testPostgresErr    error
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	stop := startTestPostgres()
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+func startTestPostgres() (stop func()) {
+	stop = func() {}
+	if dsn := os.Getenv("PROXYCHECKER_TEST_DB_DSN"); dsn != "" {
+		if driver := os.Getenv("PROXYCHECKER_TEST_DB_DRIVER"); driver != "" {
+			testPostgresDriver = driver
+		}
+		testPostgresDSN = dsn
+		return stop
+	}
+	if testing.Short() {
+		testPostgresErr = errors.New("embedded PostgreSQL is not started in -short mode")
+		return stop
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		testPostgresErr = err
+		return stop
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	dir, err := os.MkdirTemp("", "proxychecker-pg")
+	if err != nil {
+		testPostgresErr = err
+		return stop
+	}
+	pg := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(uint32(port)).
+		Database("proxychecker").
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		Logger(io.Discard))
+	if err := pg.Start(); err != nil {
+		os.RemoveAll(dir)
+		testPostgresErr = fmt.Errorf("embedded PostgreSQL: %w", err)
+		return stop
+	}
+	testPostgresDSN = fmt.Sprintf("host=127.0.0.1 port=%d user=postgres password=postgres dbname=proxychecker sslmode=disable", port)
+	return func() {
+		pg.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// forEachDatabase запускает test на SQLite и на PostgreSQL с пустой схемой.
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	t.Run(DriverSQLite, func(t *testing.T) {
+		test(t, openTestDatabase(t, DriverSQLite, filepath.Join(t.TempDir(), "proxy.db")))
+	})
+	t.Run(DriverPostgres, func(t *testing.T) {
+		if testPostgresDSN == "" {
+			t.Skipf("PostgreSQL is not available: %v", testPostgresErr)
+		}
+		test(t, openTestDatabase(t, testPostgresDriver, testPostgresDSN))
+	})
+}
+
+func openTestDatabase(t *testing.T, driver, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := OpenDatabase(driver, dsn)
+	if err != nil {
+		t.Fatalf("OpenDatabase(%s): %v", driver, err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	// База PostgreSQL общая для всех тестов - каждый начинает с пустых таблиц
+	if err := db.Migrator().DropTable(databaseModels...); err != nil {
+		t.Fatalf("drop tables: %v", err)
+	}
+	if err := db.AutoMigrate(databaseModels...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	k, err := generateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := secrets
+	secrets = newSecretKeyring([]*secretKey{k}, "env")
+	t.Cleanup(func() { secrets = prev })
+	return db
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, rows ...any) {
+	t.Helper()
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+}
+
+func failureAt(proxyID, errorType string, ts time.Time) *ProxyFailureLog {
+	return &ProxyFailureLog{ID: uuid.NewString(), ProxyID: proxyID, Timestamp: ts, ErrorType: errorType, ErrorMsg: errorType}
+}
+
+// testNow - момент отсчета тестовых данных. PostgreSQL хранит микросекунды,
+// поэтому время округлено до секунды.
+func testNow() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+func TestParseDBTime(t *testing.T) {
+	want := time.Date(2024, 3, 5, 14, 7, 9, 123456000, time.FixedZone("", 3*3600))
+	for _, value := range []string{
+		"2024-03-05 14:07:09.123456+03:00",  // SQLite
+		"2024-03-05T14:07:09.123456+03:00",  // PostgreSQL через database/sql
+		" 2024-03-05 14:07:09.123456+03:00", // лишние пробелы
+	} {
+		got, err := parseDBTime(value)
+		if err != nil {
+			t.Errorf("parseDBTime(%q): %v", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseDBTime(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if _, err := parseDBTime("yesterday"); err == nil {
+		t.Error("parseDBTime accepted an unknown format")
+	}
+}
+
+func TestMonitoringStarts(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		now := testNow()
+		mustCreate(t, db,
+			// p1: первая ошибка раньше первой записи IP
+			&ProxyIPLog{Id: uuid.NewString(), ProxyId: "p1", Timestamp: now.Add(-time.Hour)},
+			failureAt("p1", "ping_failed", now.Add(-2*time.Hour)),
+			// p2: только история IP
+			&ProxyIPLog{Id: uuid.NewString(), ProxyId: "p2", Timestamp: now.Add(-30 * time.Minute)},
+			&ProxyIPLog{Id: uuid.NewString(), ProxyId: "p2", Timestamp: now.Add(-10 * time.Minute)},
+		)
+
+		starts, err := monitoringStarts(db, []string{"p1", "p2", "p3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]time.Time{"p1": now.Add(-2 * time.Hour), "p2": now.Add(-30 * time.Minute)}
+		if len(starts) != len(want) {
+			t.Fatalf("starts = %v, want %v", starts, want)
+		}
+		for id, w := range want {
+			if !starts[id].Equal(w) {
+				t.Errorf("start of %s = %v, want %v", id, starts[id], w)
+			}
+		}
+	})
+}
+
+func TestGetFailureStats(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		now := testNow()
+		from, to := now.Add(-24*time.Hour), now
+		last := now.Add(-time.Hour)
+		mustCreate(t, db,
+			failureAt("p1", "ping_failed", now.Add(-5*time.Hour)),
+			failureAt("p1", "ping_failed", now.Add(-4*time.Hour)),
+			failureAt("p1", "speed_check_failed", now.Add(-3*time.Hour)),
+			failureAt("p2", "ip_check_failed", last),
+			failureAt("p1", "ping_failed", now.Add(-48*time.Hour)), // до периода
+			failureAt("p3", "ping_failed", now.Add(-2*time.Hour)),  // прокси не в выборке
+			&Incident{ID: uuid.NewString(), ProxyID: "p1", Status: IncidentClosed,
+				StartedAt: now.Add(-5 * time.Hour), EndedAt: now.Add(-5*time.Hour + 10*time.Minute), Duration: 600},
+		)
+
+		loc := time.FixedZone("UTC+5", 5*3600)
+		stats, err := GetFailureStats(db, []Proxy{{Id: "p1"}, {Id: "p2"}}, false, from, to, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.TotalFailures != 4 || stats.PingFailures != 2 || stats.SpeedFailures != 1 || stats.IPCheckFailures != 1 {
+			t.Errorf("counts = total %d, ping %d, speed %d, ip %d; want 4, 2, 1, 1",
+				stats.TotalFailures, stats.PingFailures, stats.SpeedFailures, stats.IPCheckFailures)
+		}
+		if len(stats.ByErrorType) != 3 || stats.ByErrorType[0].ErrorType != "ping_failed" {
+			t.Errorf("by error type = %+v, want ping_failed first of 3", stats.ByErrorType)
+		}
+		if stats.LastFailure == nil || !stats.LastFailure.Equal(last) {
+			t.Errorf("last failure = %v, want %v", stats.LastFailure, last)
+		}
+		var byHour int64
+		for _, n := range stats.ByHour {
+			byHour += n
+		}
+		if byHour != 4 {
+			t.Errorf("by hour sums to %d, want 4", byHour)
+		}
+		if stats.ByHour[last.In(loc).Hour()] == 0 {
+			t.Errorf("by hour = %v, no failure in hour %d of %s", stats.ByHour, last.In(loc).Hour(), loc)
+		}
+		if stats.Incidents != 1 || stats.MTTR != 600 {
+			t.Errorf("incidents = %d, mttr = %d; want 1, 600", stats.Incidents, stats.MTTR)
+		}
+
+		// Весь парк считается без фильтра по прокси
+		fleet, err := GetFailureStats(db, []Proxy{{Id: "p1"}, {Id: "p2"}}, true, from, to, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fleet.TotalFailures != 5 || fleet.ByHour[now.Add(-2*time.Hour).In(loc).Hour()] == 0 {
+			t.Errorf("fleet total = %d, by hour = %v; want 5 with p3", fleet.TotalFailures, fleet.ByHour)
+		}
+	})
+}
+
+func TestIncidentList(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		now := testNow()
+		open := &Incident{ID: "open", ProxyID: "p1", Status: IncidentOpen, StartedAt: now.Add(-3 * time.Hour)}
+		recent := &Incident{ID: "recent", ProxyID: "p1", Status: IncidentClosed,
+			StartedAt: now.Add(-2 * time.Hour), EndedAt: now.Add(-time.Hour), Duration: 3600}
+		old := &Incident{ID: "old", ProxyID: "p1", Status: IncidentClosed,
+			StartedAt: now.Add(-10 * time.Hour), EndedAt: now.Add(-8 * time.Hour), Duration: 7200}
+		other := &Incident{ID: "other", ProxyID: "p2", Status: IncidentClosed,
+			StartedAt: now.Add(-4 * time.Hour), EndedAt: now.Add(-3 * time.Hour), Duration: 3600}
+		mustCreate(t, db, open, recent, old, other)
+
+		tests := []struct {
+			name    string
+			filters IncidentFilters
+			want    []string
+		}{
+			{"all", IncidentFilters{}, []string{"recent", "open", "other", "old"}},
+			{"proxy", IncidentFilters{ProxyID: "p1"}, []string{"recent", "open", "old"}},
+			{"open", IncidentFilters{Status: IncidentOpen}, []string{"open"}},
+			{"overlapping the last 5 hours", IncidentFilters{StartDate: now.Add(-5 * time.Hour)}, []string{"recent", "open", "other"}},
+			{"started before 9 hours ago", IncidentFilters{EndDate: now.Add(-9 * time.Hour)}, []string{"old"}},
+			{"second page", IncidentFilters{Page: 2, PageSize: 3}, []string{"old"}},
+		}
+		for _, tt := range tests {
+			if tt.filters.PageSize == 0 {
+				tt.filters.PageSize = 50
+			}
+			list, count, err := (&Incident{}).List(tt.filters, db)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			ids := make([]string, len(list))
+			for i, inc := range list {
+				ids[i] = inc.ID
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+			}
+			if tt.filters.Page <= 1 && count != int64(len(tt.want)) {
+				t.Errorf("%s: count = %d, want %d", tt.name, count, len(tt.want))
+			}
+			for _, inc := range list {
+				if inc.ID == "open" && inc.Duration < 3*3600 {
+					t.Errorf("%s: open incident duration = %d, want at least %d", tt.name, inc.Duration, 3*3600)
+				}
+			}
+		}
+	})
+}
+
+func TestAuditEventList(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		now := testNow()
+		event := func(id, actor, action string, ago time.Duration) *AuditEvent {
+			return &AuditEvent{ID: id, Time: now.Add(-ago), Actor: actor, Action: action, EntityType: AuditProxy,
+				Changes: map[string]AuditChange{"name": {Before: "a", After: "b"}}, Success: true}
+		}
+		mustCreate(t, db,
+			event("e1", "admin", AuditCreate, 3*time.Hour),
+			event("e2", "admin", AuditUpdate, 2*time.Hour),
+			event("e3", "token:ci", AuditDelete, time.Hour),
+		)
+
+		tests := []struct {
+			name    string
+			filters AuditFilters
+			want    []string
+		}{
+			{"all", AuditFilters{}, []string{"e3", "e2", "e1"}},
+			{"actor", AuditFilters{Actor: "admin"}, []string{"e2", "e1"}},
+			{"action", AuditFilters{Action: AuditDelete}, []string{"e3"}},
+			{"period", AuditFilters{StartDate: now.Add(-150 * time.Minute), EndDate: now.Add(-time.Hour)}, []string{"e2"}},
+		}
+		for _, tt := range tests {
+			tt.filters.PageSize = 50
+			list, count, err := (&AuditEvent{}).List(tt.filters, db)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			ids := make([]string, len(list))
+			for i, e := range list {
+				ids[i] = e.ID
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") || count != int64(len(tt.want)) {
+				t.Errorf("%s: got %v (count %d), want %v", tt.name, ids, count, tt.want)
+			}
+		}
+
+		list, _, err := (&AuditEvent{}).List(AuditFilters{Action: AuditCreate, PageSize: 50}, db)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("create events = %v, %v", list, err)
+		}
+		if c := list[0].Changes["name"]; c.Before != "a" || c.After != "b" {
+			t.Errorf("changes = %+v, want name a -> b", list[0].Changes)
+		}
+	})
+}
+
+func TestEncryptLegacySecrets(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		mustCreate(t, db,
+			&Proxy{Id: "plain", Ip: "10.0.0.1", Port: "8080", Password: "x"},
+			&Proxy{Id: "empty", Ip: "10.0.0.2", Port: "8080"},
+			&Proxy{Id: "encrypted", Ip: "10.0.0.3", Port: "8080", Password: "kept"},
+		)
+		stg := &Settings{Url: "https://example.com", SlackWebhookURL: "https://hooks.example.com/x"}
+		if err := stg.Save(db); err != nil {
+			t.Fatal(err)
+		}
+
+		// Так секреты лежат в базе после версий без шифрования
+		if err := db.Exec("UPDATE proxies SET password = ? WHERE id = ?", "legacy-pass", "plain").Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Exec("UPDATE settings SET telegram_token = ?, smtp_password = ? WHERE id = ?", "legacy-token", "legacy-smtp", 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		var before string
+		if err := db.Raw("SELECT password FROM proxies WHERE id = ?", "encrypted").Scan(&before).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if err := EncryptLegacySecrets(db); err != nil {
+			t.Fatal(err)
+		}
+
+		var passwords []struct {
+			Id       string
+			Password string
+		}
+		if err := db.Raw("SELECT id, password FROM proxies ORDER BY id").Scan(&passwords).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range passwords {
+			switch {
+			case p.Id == "empty" && p.Password != "":
+				t.Errorf("empty password stored as %q", p.Password)
+			case p.Id == "encrypted" && p.Password != before:
+				t.Errorf("already encrypted password was rewritten")
+			case p.Id != "empty" && !strings.HasPrefix(p.Password, encryptedPrefix):
+				t.Errorf("password of %s is stored as %q, want encrypted", p.Id, p.Password)
+			}
+		}
+		var raw struct {
+			TelegramToken   string
+			SMTPPassword    string `gorm:"column:smtp_password"`
+			SlackWebhookURL string
+		}
+		if err := db.Raw("SELECT telegram_token, smtp_password, slack_webhook_url FROM settings WHERE id = ?", 1).Scan(&raw).Error; err != nil {
+			t.Fatal(err)
+		}
+		for column, value := range map[string]string{"telegram_token": raw.TelegramToken, "smtp_password": raw.SMTPPassword, "slack_webhook_url": raw.SlackWebhookURL} {
+			if !strings.HasPrefix(value, encryptedPrefix) {
+				t.Errorf("settings.%s is stored as %q, want encrypted", column, value)
+			}
+		}
+
+		var p Proxy
+		if err := db.First(&p, "id = ?", "plain").Error; err != nil || p.Password != "legacy-pass" {
+			t.Errorf("decrypted password = %q, %v; want legacy-pass", p.Password, err)
+		}
+		loaded, err := (&Settings{}).Get(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.TelegramToken != "legacy-token" || loaded.SMTPPassword != "legacy-smtp" || loaded.SlackWebhookURL != "https://hooks.example.com/x" {
+			t.Errorf("decrypted settings = %q, %q, %q", loaded.TelegramToken, loaded.SMTPPassword, loaded.SlackWebhookURL)
+		}
+
+		// Второй запуск ничего не находит
+		if err := EncryptLegacySecrets(db); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestVisitLogsDomainFilter(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		now := testNow()
+		for i, domain := range []string{"Example.COM", "api.example.com", "other.org"} {
+			mustCreate(t, db, &ProxyVisitLogs{Id: uuid.NewString(), ProxyId: "p1", Timestamp: now.Add(time.Duration(-i) * time.Minute), Domain: domain})
+		}
+
+		for _, query := range []string{"example", "EXAMPLE.com"} {
+			logs, count, err := (&ProxyVisitLogs{}).List(ProxyVisitLogsFilters{Domain: query, PageSize: 50}, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 || len(logs) != 2 {
+				t.Errorf("domain %q: got %d rows (count %d), want 2", query, len(logs), count)
+			}
+		}
+	})
+}
+
+func TestLoadSecretsRefusesNewKeyForEncryptedData(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		t.Setenv(envMasterKey, "")
+		t.Setenv(envMasterKeyFile, filepath.Join(t.TempDir(), "master.key"))
+
+		// Пустая база: ключ создается
+		if err := LoadSecrets(db); err != nil {
+			t.Fatalf("LoadSecrets on an empty database: %v", err)
+		}
+		mustCreate(t, db, &Proxy{Id: "p1", Ip: "10.0.0.1", Port: "8080", Password: "secret"})
+
+		// Файл ключа потерян, а в базе уже есть зашифрованные значения
+		t.Setenv(envMasterKeyFile, filepath.Join(t.TempDir(), "master.key"))
+		err := LoadSecrets(db)
+		if err == nil || !strings.Contains(err.Error(), envMasterKey) {
+			t.Fatalf("LoadSecrets with a lost key = %v, want an error naming %s", err, envMasterKey)
+		}
+		if _, statErr := os.Stat(os.Getenv(envMasterKeyFile)); !errors.Is(statErr, os.ErrNotExist) {
+			t.Errorf("a new key file was written: %v", statErr)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"strings"
 	"time"
 
@@ -11,18 +12,47 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// Поддерживаемые протоколы прокси
+const (
+	SchemeHTTP    = "http"
+	SchemeHTTPS   = "https"
+	SchemeSOCKS4  = "socks4"
+	SchemeSOCKS5  = "socks5"
+	SchemeSOCKS5H = "socks5h"
+)
+
+// normalizeScheme приводит протокол к нижнему регистру и проверяет, что он поддерживается.
+// Пустое значение трактуется как http для обратной совместимости.
+func normalizeScheme(scheme string) (string, error) {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	switch scheme {
+	case "":
+		return SchemeHTTP, nil
+	case SchemeHTTP, SchemeHTTPS, SchemeSOCKS4, SchemeSOCKS5, SchemeSOCKS5H:
+		return scheme, nil
+	}
+	return "", fmt.Errorf("unsupported proxy scheme %q", scheme)
+}
+
 type Proxy struct {
 	Id           string    `json:"id"`
+	Scheme       string    `json:"scheme" gorm:"default:http"`
 	Ip           string    `json:"ip"`
 	Port         string    `json:"port"`
 	Username     string    `json:"username"`
-	Password     string    `json:"password"`
+	Password     string    `json:"password" gorm:"serializer:encrypted"`
 	LastLatency  int       `json:"lastLatency"`
 	Tag          string    `json:"tag"`
 	LastStatus   int       `json:"lastStatus"`
 	Failures     int       `json:"failures"`
 	RealIP       string    `json:"realIP"`
 	RealCountry  string    `json:"realCountry"`
+	RealCountryCode string `json:"realCountryCode"`
+	RealCity     string    `json:"realCity"`
+	ASN          uint      `json:"asn"`
+	Organization string    `json:"organization"`
+	MCC          string    `json:"mcc"` // Mobile country code
+	MNC          string    `json:"mnc"` // Mobile network code
 	Contacts     string    `json:"contacts"`
 	LastIPChange time.Time `json:"last_ip_change"`
 	Operator     string    `json:"operator"`
@@ -32,6 +62,8 @@ type Proxy struct {
 	Name         string    `json:"name"`
 	Uptime       int       `json:"uptime"`
 	LastCheck    time.Time `json:"last_check"`
+	CheckInterval int      `json:"checkInterval"` // IP check interval in minutes (0 = tag or global)
+	SLATarget    float64   `json:"slaTarget"`     // Availability target, percent (0 = tag target)
 
 	Stack        bool      `json:"stack"`
 }
@@ -59,11 +91,19 @@ func (s *Proxy) Get(db *gorm.DB, id string) error {
 	return db.Where("id =?", id).First(&s).Error
 }
 
-func (p *Proxy) Parse(proxy string) {
+// Parse разбирает строку прокси. Неизвестный протокол - ошибка: молча
+// считать такой прокси http значит показывать его мертвым.
+func (p *Proxy) Parse(proxy string) error {
 	proxy = strings.TrimSpace(proxy)
-	proxy = strings.Replace(proxy, "http://", "", -1)
-	proxy = strings.Replace(proxy, "https://", "", -1)
-	proxy = strings.Replace(proxy, "socks5://", "", -1)
+	p.Scheme = SchemeHTTP
+	if i := strings.Index(proxy, "://"); i >= 0 {
+		scheme, err := normalizeScheme(proxy[:i])
+		if err != nil {
+			return err
+		}
+		p.Scheme = scheme
+		proxy = proxy[i+3:]
+	}
 
 	if strings.Contains(proxy, "@") {
 		// Format: username:password@ip:port or ip:port@username:password
@@ -124,13 +164,36 @@ func (p *Proxy) Parse(proxy string) {
 			p.Ip = ips[0].String()
 		}
 	}
+	return nil
 }
 
+// String возвращает прокси в формате для экспорта. Для не-http прокси
+// добавляется префикс протокола, чтобы Parse при импорте его восстановил.
 func (s *Proxy) String() string {
+	str := fmt.Sprintf("%s:%s", s.Ip, s.Port)
 	if s.Username != "" && s.Password != "" {
-		return fmt.Sprintf("%s:%s@%s:%s", s.Username, s.Password, s.Ip, s.Port)
+		str = fmt.Sprintf("%s:%s@%s:%s", s.Username, s.Password, s.Ip, s.Port)
+	}
+	if s.Scheme != "" && s.Scheme != SchemeHTTP {
+		str = s.Scheme + "://" + str
+	}
+	return str
+}
+
+// URL возвращает адрес прокси с протоколом и данными для аутентификации.
+func (s *Proxy) URL() *url.URL {
+	scheme, err := normalizeScheme(s.Scheme)
+	if err != nil {
+		scheme = SchemeHTTP
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(s.Ip, s.Port),
+	}
+	if s.Username != "" {
+		u.User = url.UserPassword(s.Username, s.Password)
 	}
-	return fmt.Sprintf("%s:%s", s.Ip, s.Port)
+	return u
 }
 
 type ProxyVisitLogs struct {
@@ -183,7 +246,8 @@ func (p *ProxyVisitLogs) buildWhereTargetIP(targetIP string) func(*gorm.DB) *gor
 
 func (p *ProxyVisitLogs) buildWhereDomain(domain string) func(*gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		return db.Where("domain LIKE ?", "%"+domain+"%")
+		// LIKE в PostgreSQL учитывает регистр, в SQLite - нет; приводим обе стороны
+		return db.Where("LOWER(domain) LIKE ?", "%"+strings.ToLower(domain)+"%")
 	}
 }
 
@@ -465,6 +529,16 @@ type ProxyIPLog struct {
 	OldCountry string    `json:"old_country"`
 	ISP        string    `json:"isp"`
 	OldISP     string    `json:"old_isp"`
+	CountryCode  string  `json:"country_code"`
+	City         string  `json:"city"`
+	OldCity      string  `json:"old_city"`
+	Organization string  `json:"organization"`
+	ASN          uint    `json:"asn"`
+	OldASN       uint    `json:"old_asn"`
+	MCC          string  `json:"mcc"`
+	OldMCC       string  `json:"old_mcc"`
+	MNC          string  `json:"mnc"`
+	OldMNC       string  `json:"old_mnc"`
 	Stack      bool      `json:"stack"` // новое поле
 }
 
@@ -9,12 +9,13 @@ import (
 
 // ProxyFailureLog represents a failure event for a proxy
 type ProxyFailureLog struct {
-	ID        string    `json:"id" gorm:"primaryKey"`
-	ProxyID   string    `json:"proxy_id" gorm:"index"`
-	Timestamp time.Time `json:"timestamp" gorm:"index"`
-	ErrorType string    `json:"error_type"` // "ping_failed", "speed_check_failed", "ip_check_failed"
-	ErrorMsg  string    `json:"error_msg"`
-	Latency   int       `json:"latency"` // Last known latency before failure
+	ID         string    `json:"id" gorm:"primaryKey"`
+	ProxyID    string    `json:"proxy_id" gorm:"index"`
+	Timestamp  time.Time `json:"timestamp" gorm:"index"`
+	ErrorType  string    `json:"error_type"` // "ping_failed", "speed_check_failed", "ip_check_failed"
+	ErrorMsg   string    `json:"error_msg"`
+	Latency    int       `json:"latency"`                  // Last known latency before failure
+	IncidentID string    `json:"incident_id" gorm:"index"` // Outage this failure belongs to (empty if the proxy stayed alive)
 }
 
 // ProxyFailureLogFilters represents filters for querying failure logs
@@ -171,57 +172,142 @@ func (f *ProxyFailureLog) List(filters ProxyFailureLogFilters, db *gorm.DB) ([]P
 	return logs, count, err
 }
 
-// GetStats returns failure statistics for a proxy
+// FailureStats - статистика ошибок прокси (или группы прокси) за период.
 type FailureStats struct {
-	TotalFailures     int64   `json:"total_failures"`
-	PingFailures      int64   `json:"ping_failures"`
-	SpeedFailures     int64   `json:"speed_failures"`
-	IPCheckFailures   int64   `json:"ip_check_failures"`
-	LastFailure       *time.Time `json:"last_failure"`
-	FailureRate       float64 `json:"failure_rate"` // Failures per day
-}
-
-// GetFailureStats calculates failure statistics for a proxy
-func GetFailureStats(db *gorm.DB, proxyID string, days int) (*FailureStats, error) {
-	stats := &FailureStats{}
-
-	startDate := time.Now().AddDate(0, 0, -days)
-
-	// Total failures
-	db.Model(&ProxyFailureLog{}).
-		Where("proxy_id = ? AND timestamp > ?", proxyID, startDate).
-		Count(&stats.TotalFailures)
-
-	// Ping failures
-	db.Model(&ProxyFailureLog{}).
-		Where("proxy_id = ? AND timestamp > ? AND error_type = ?", proxyID, startDate, "ping_failed").
-		Count(&stats.PingFailures)
-
-	// Speed check failures
-	db.Model(&ProxyFailureLog{}).
-		Where("proxy_id = ? AND timestamp > ? AND error_type = ?", proxyID, startDate, "speed_check_failed").
-		Count(&stats.SpeedFailures)
-
-	// IP check failures
-	db.Model(&ProxyFailureLog{}).
-		Where("proxy_id = ? AND timestamp > ? AND error_type = ?", proxyID, startDate, "ip_check_failed").
-		Count(&stats.IPCheckFailures)
-
-	// Last failure
-	var lastLog ProxyFailureLog
-	err := db.Model(&ProxyFailureLog{}).
-		Where("proxy_id = ?", proxyID).
-		Order("timestamp desc").
-		Limit(1).
-		First(&lastLog).Error
-
-	if err == nil {
-		stats.LastFailure = &lastLog.Timestamp
-	}
-
-	// Calculate failure rate (failures per day)
-	if days > 0 {
-		stats.FailureRate = float64(stats.TotalFailures) / float64(days)
+	From            time.Time          `json:"from"`
+	To              time.Time          `json:"to"`
+	Proxies         int                `json:"proxies"`
+	TotalFailures   int64              `json:"total_failures"`
+	PingFailures    int64              `json:"ping_failures"`
+	SpeedFailures   int64              `json:"speed_failures"`
+	IPCheckFailures int64              `json:"ip_check_failures"`
+	LastFailure     *time.Time         `json:"last_failure"`
+	FailureRate     float64            `json:"failure_rate"` // Failures per day
+	ByErrorType     []FailureTypeCount `json:"by_error_type"`
+	ByHour          [24]int64          `json:"by_hour"` // Failures per hour of day
+	Incidents       int                `json:"incidents"`
+	MTBF            int64              `json:"mtbf"` // Mean time between failures (incidents), seconds; 0 = no incidents
+	MTTR            int64              `json:"mttr"` // Mean time to recovery of closed incidents, seconds
+}
+
+// Размер отрезка, по которым база группирует ошибки для разбивки по часам суток
+const failureBucketSeconds = 900 // 15 минут, то же число в failureBucketExpr
+
+// failureBucketExpr возвращает SQL-выражение с номером 15-минутного отрезка
+// (Unix-время / failureBucketSeconds) для времени ошибки. SQLite хранит время
+// строкой с зоной, strftime('%s') приводит ее к UTC.
+func failureBucketExpr(db *gorm.DB) string {
+	if db.Dialector.Name() == DriverPostgres {
+		return "FLOOR(EXTRACT(EPOCH FROM timestamp) / 900)::bigint"
+	}
+	return "CAST(strftime('%s', timestamp) AS INTEGER) / 900"
+}
+
+// FailureTypeCount - число ошибок одного типа.
+type FailureTypeCount struct {
+	ErrorType string `json:"error_type"`
+	Count     int64  `json:"count"`
+}
+
+// GetFailureStats считает статистику ошибок прокси за период [from, to).
+// Часы суток считаются в часовом поясе loc. fleet - статистика всего парка:
+// ошибки и инциденты берутся без фильтра по списку прокси.
+func GetFailureStats(db *gorm.DB, proxies []Proxy, fleet bool, from, to time.Time, loc *time.Location) (*FailureStats, error) {
+	from, to = from.In(time.Local), to.In(time.Local)
+	stats := &FailureStats{From: from, To: to, Proxies: len(proxies), ByErrorType: []FailureTypeCount{}}
+	if len(proxies) == 0 {
+		return stats, nil
+	}
+	ids := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		ids = append(ids, p.Id)
+	}
+	byProxy := func(query *gorm.DB) *gorm.DB {
+		if fleet {
+			return query
+		}
+		return query.Where("proxy_id IN ?", ids)
+	}
+	inWindow := func() *gorm.DB {
+		return byProxy(db.Model(&ProxyFailureLog{})).Where("timestamp >= ? AND timestamp < ?", from, to)
+	}
+
+	// Разбивка по типам одним запросом
+	var types []struct {
+		ErrorType string
+		Count     int64
+		Last      string
+	}
+	if err := inWindow().
+		Select("error_type, COUNT(*) AS count, MAX(timestamp) AS last").
+		Group("error_type").
+		Order("count DESC").
+		Scan(&types).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		stats.TotalFailures += t.Count
+		stats.ByErrorType = append(stats.ByErrorType, FailureTypeCount{ErrorType: t.ErrorType, Count: t.Count})
+		switch t.ErrorType {
+		case "ping_failed":
+			stats.PingFailures = t.Count
+		case "speed_check_failed":
+			stats.SpeedFailures = t.Count
+		case "ip_check_failed":
+			stats.IPCheckFailures = t.Count
+		}
+		if last, err := parseDBTime(t.Last); err == nil && (stats.LastFailure == nil || last.After(*stats.LastFailure)) {
+			stats.LastFailure = &last
+		}
+	}
+
+	// База группирует ошибки по 15 минутам, час суток в loc считаем по
+	// началу отрезка: смещения всех часовых поясов кратны 15 минутам
+	var buckets []struct {
+		Bucket int64
+		Count  int64
+	}
+	if err := inWindow().
+		Select(failureBucketExpr(db)+" AS bucket, COUNT(*) AS count").
+		Group("bucket").
+		Scan(&buckets).Error; err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		stats.ByHour[time.Unix(b.Bucket*failureBucketSeconds, 0).In(loc).Hour()] += b.Count
+	}
+
+	if days := to.Sub(from).Hours() / 24; days > 0 {
+		stats.FailureRate = float64(stats.TotalFailures) / days
+	}
+
+	// MTBF - время работы между инцидентами, MTTR - средняя длительность
+	// закрытых инцидентов
+	availability, err := ProxyAvailability(db, proxies, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var uptime int64
+	for _, a := range availability {
+		uptime += a.Monitored - a.Downtime
+		stats.Incidents += a.Incidents
+	}
+	if stats.Incidents > 0 {
+		stats.MTBF = uptime / int64(stats.Incidents)
+	}
+
+	var mttr struct {
+		Count    int64
+		Duration int64
+	}
+	if err := byProxy(db.Model(&Incident{})).
+		Select("COUNT(*) AS count, COALESCE(SUM(duration), 0) AS duration").
+		Where("status = ? AND ended_at >= ? AND ended_at < ?", IncidentClosed, from, to).
+		Scan(&mttr).Error; err != nil {
+		return nil, err
+	}
+	if mttr.Count > 0 {
+		stats.MTTR = mttr.Duration / mttr.Count
 	}
 
 	return stats, nil
@@ -1,45 +1,347 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/oschwald/maxminddb-golang"
 )
 
+// ErrGeoIPUnavailable возвращается, когда ни одна база GeoIP не загружена.
+var ErrGeoIPUnavailable = errors.New("GeoIP databases are not loaded")
+
+// GeoIPPaths - пути к базам MaxMind. Все базы опциональны: без ISP-базы
+// оператор не определяется, без всех баз клиент работает в режиме "no GeoIP".
+type GeoIPPaths struct {
+	ISP     string
+	Country string
+	City    string
+	ASN     string
+}
+
+// geoDatabases - набор открытых баз, который подменяется целиком при перезагрузке.
+type geoDatabases struct {
+	ispDb     *maxminddb.Reader
+	countryDb *maxminddb.Reader
+	cityDb    *maxminddb.Reader
+	asnDb     *maxminddb.Reader
+}
+
+func (d *geoDatabases) readers() []*maxminddb.Reader {
+	return []*maxminddb.Reader{d.ispDb, d.countryDb, d.cityDb, d.asnDb}
+}
+
+func (d *geoDatabases) close() error {
+	var errs []error
+	for _, db := range d.readers() {
+		if db != nil {
+			errs = append(errs, db.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GeoIPClient безопасен для конкурентного использования: воркеры планировщика
+// читают базы под RLock, а Reload подменяет их под Lock, поэтому старые
+// mmap-файлы закрываются только после завершения всех текущих запросов.
 type GeoIPClient struct {
-	ispDb *maxminddb.Reader
+	paths GeoIPPaths
+
+	mu       sync.RWMutex
+	dbs      geoDatabases
+	loadedAt time.Time
 }
 
 type IpData struct {
-	ISP string
+	ISP          string
+	Organization string
+	ASN          uint
+	MCC          string
+	MNC          string
+	City         string
+	Country      string
+	CountryCode  string
+}
+
+type geoCountry struct {
+	IsoCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
 }
 
 func (c *GeoIPClient) ReadData(ip string) (data IpData, err error) {
 	parsdIp := net.ParseIP(ip)
-	var isp_record struct {
-		Isp               string `maxminddb:"isp"`
-		MobileNetworkCode string `maxminddb:"mobile_network_code"`
+	if parsdIp == nil {
+		return data, errors.New("invalid IP address: " + ip)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dbs := c.dbs
+	if dbs.ispDb == nil && dbs.countryDb == nil && dbs.cityDb == nil && dbs.asnDb == nil {
+		return data, ErrGeoIPUnavailable
+	}
+
+	if dbs.ispDb != nil {
+		var isp_record struct {
+			Isp               string `maxminddb:"isp"`
+			Organization      string `maxminddb:"organization"`
+			ASN               uint   `maxminddb:"autonomous_system_number"`
+			ASOrganization    string `maxminddb:"autonomous_system_organization"`
+			MobileCountryCode string `maxminddb:"mobile_country_code"`
+			MobileNetworkCode string `maxminddb:"mobile_network_code"`
+		}
+		if err := dbs.ispDb.Lookup(parsdIp, &isp_record); err != nil {
+			return data, err
+		}
+		data.ISP = isp_record.Isp
+		data.Organization = isp_record.Organization
+		if data.Organization == "" {
+			data.Organization = isp_record.ASOrganization
+		}
+		data.ASN = isp_record.ASN
+		data.MCC = isp_record.MobileCountryCode
+		data.MNC = isp_record.MobileNetworkCode
+	}
+
+	// ASN-база дополняет данные, если в ISP-базе номера AS нет
+	if dbs.asnDb != nil && data.ASN == 0 {
+		var asn_record struct {
+			ASN            uint   `maxminddb:"autonomous_system_number"`
+			ASOrganization string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := dbs.asnDb.Lookup(parsdIp, &asn_record); err != nil {
+			log.Printf("GeoIP: ASN lookup failed for %s: %v", ip, err)
+		} else {
+			data.ASN = asn_record.ASN
+			if data.Organization == "" {
+				data.Organization = asn_record.ASOrganization
+			}
+		}
 	}
-	if err := c.ispDb.Lookup(parsdIp, &isp_record); err != nil {
-		return data, err
+
+	// City-база содержит и страну, поэтому Country-база нужна только без нее
+	switch {
+	case dbs.cityDb != nil:
+		var city_record struct {
+			City struct {
+				Names map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
+			Country geoCountry `maxminddb:"country"`
+		}
+		if err := dbs.cityDb.Lookup(parsdIp, &city_record); err != nil {
+			log.Printf("GeoIP: city lookup failed for %s: %v", ip, err)
+		} else {
+			data.City = city_record.City.Names["en"]
+			data.Country = city_record.Country.Names["en"]
+			data.CountryCode = city_record.Country.IsoCode
+		}
+	case dbs.countryDb != nil:
+		var country_record struct {
+			Country geoCountry `maxminddb:"country"`
+		}
+		if err := dbs.countryDb.Lookup(parsdIp, &country_record); err != nil {
+			log.Printf("GeoIP: country lookup failed for %s: %v", ip, err)
+		} else {
+			data.Country = country_record.Country.Names["en"]
+			data.CountryCode = country_record.Country.IsoCode
+		}
 	}
-	data.ISP = isp_record.Isp
 
 	return
 }
 
-// Close closes the GeoIP database connection
-func (c *GeoIPClient) Close() error {
-	if c.ispDb != nil {
-		return c.ispDb.Close()
+// Reload заново открывает базы с диска и атомарно подменяет текущие.
+// Если какую-то базу открыть не удалось (например, файл еще копируется),
+// в работе остается ее прежняя версия; закрываются только замененные базы.
+func (c *GeoIPClient) Reload() error {
+	opened := geoDatabases{
+		ispDb:     openGeoDb(c.paths.ISP),
+		countryDb: openGeoDb(c.paths.Country),
+		cityDb:    openGeoDb(c.paths.City),
+		asnDb:     openGeoDb(c.paths.ASN),
 	}
+
+	c.mu.Lock()
+	var replaced geoDatabases
+	kept := 0
+	swap := func(current **maxminddb.Reader, next *maxminddb.Reader, old **maxminddb.Reader) {
+		if next == nil {
+			if *current != nil {
+				kept++
+			}
+			return
+		}
+		*old, *current = *current, next
+	}
+	swap(&c.dbs.ispDb, opened.ispDb, &replaced.ispDb)
+	swap(&c.dbs.countryDb, opened.countryDb, &replaced.countryDb)
+	swap(&c.dbs.cityDb, opened.cityDb, &replaced.cityDb)
+	swap(&c.dbs.asnDb, opened.asnDb, &replaced.asnDb)
+	c.loadedAt = time.Now()
+	available := c.available()
+	c.mu.Unlock()
+
+	if err := replaced.close(); err != nil {
+		log.Printf("GeoIP: error closing previous databases: %v", err)
+	}
+
+	if !available {
+		log.Println("GeoIP: no databases loaded, running without GeoIP enrichment")
+		return ErrGeoIPUnavailable
+	}
+	if kept > 0 {
+		return fmt.Errorf("%d GeoIP databases could not be opened, keeping their previously loaded version", kept)
+	}
+	log.Println("GeoIP: databases reloaded")
 	return nil
 }
 
-func NewGeoIPClient(ispPatch string) (*GeoIPClient, error) {
-	idb, err := maxminddb.Open(ispPatch)
+// available сообщает, загружена ли хотя бы одна база. Вызывается под mu.
+func (c *GeoIPClient) available() bool {
+	for _, db := range c.dbs.readers() {
+		if db != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GeoIPDatabaseStatus описывает одну базу для API.
+type GeoIPDatabaseStatus struct {
+	Path      string    `json:"path"`
+	Loaded    bool      `json:"loaded"`
+	Type      string    `json:"type,omitempty"`
+	BuildTime time.Time `json:"build_time,omitempty"`
+}
+
+// GeoIPStatus - состояние клиента для API.
+type GeoIPStatus struct {
+	Available bool                           `json:"available"`
+	LoadedAt  time.Time                      `json:"loaded_at"`
+	Databases map[string]GeoIPDatabaseStatus `json:"databases"`
+}
+
+func (c *GeoIPClient) Status() GeoIPStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := GeoIPStatus{
+		Available: c.available(),
+		LoadedAt:  c.loadedAt,
+		Databases: make(map[string]GeoIPDatabaseStatus),
+	}
+	describe := func(name, path string, db *maxminddb.Reader) {
+		if path == "" {
+			return
+		}
+		s := GeoIPDatabaseStatus{Path: path, Loaded: db != nil}
+		if db != nil {
+			s.Type = db.Metadata.DatabaseType
+			s.BuildTime = time.Unix(int64(db.Metadata.BuildEpoch), 0)
+		}
+		status.Databases[name] = s
+	}
+	describe("isp", c.paths.ISP, c.dbs.ispDb)
+	describe("country", c.paths.Country, c.dbs.countryDb)
+	describe("city", c.paths.City, c.dbs.cityDb)
+	describe("asn", c.paths.ASN, c.dbs.asnDb)
+
+	return status
+}
+
+// Close closes the GeoIP database connections
+func (c *GeoIPClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.dbs.close()
+	c.dbs = geoDatabases{}
+	return err
+}
+
+// NewGeoIPClient открывает доступные базы. Отсутствие баз не является ошибкой:
+// клиент запускается в режиме "no GeoIP" и подхватит базы при перезагрузке.
+func NewGeoIPClient(paths GeoIPPaths) *GeoIPClient {
+	c := &GeoIPClient{paths: paths}
+	if err := c.Reload(); err != nil {
+		log.Printf("GeoIP: %v", err)
+	}
+	return c
+}
+
+// WatchGeoIP периодически проверяет время изменения файлов баз и
+// перезагружает их, когда MaxMind выкладывает новую версию. wg.Add(1)
+// делает вызывающий до запуска горутины.
+func WatchGeoIP(wg *sync.WaitGroup, quit <-chan struct{}, c *GeoIPClient, interval time.Duration) {
+	defer wg.Done()
+
+	paths := []string{c.paths.ISP, c.paths.Country, c.paths.City, c.paths.ASN}
+	modTimes := geoModTimes(paths)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			current := geoModTimes(paths)
+			changed := false
+			for path, mt := range current {
+				if !mt.Equal(modTimes[path]) {
+					changed = true
+					log.Printf("GeoIP: %s changed on disk", path)
+				}
+			}
+			if !changed {
+				continue
+			}
+			modTimes = current
+			if err := c.Reload(); err != nil {
+				log.Printf("GeoIP: reload after file change failed: %v", err)
+			}
+		case <-quit:
+			log.Println("GeoIP: Shutting down database watcher.")
+			return
+		}
+	}
+}
+
+// geoModTimes возвращает время изменения каждого файла (нулевое, если файла нет).
+func geoModTimes(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		var mt time.Time
+		if info, err := os.Stat(path); err == nil {
+			mt = info.ModTime()
+		}
+		times[path] = mt
+	}
+	return times
+}
+
+// openGeoDb открывает базу, если она есть на диске.
+func openGeoDb(path string) *maxminddb.Reader {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Printf("GeoIP: database %s not found, skipping", path)
+		return nil
+	}
+	db, err := maxminddb.Open(path)
 	if err != nil {
-		return nil, err
+		log.Printf("GeoIP: failed to open %s: %v", path, err)
+		return nil
 	}
-	return &GeoIPClient{ispDb: idb}, nil
+	log.Printf("GeoIP: loaded %s (%s)", path, db.Metadata.DatabaseType)
+	return db
 }
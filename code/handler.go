@@ -18,12 +18,17 @@ import (
 
 type handler struct {
 	db            *gorm.DB
-	settings      *Settings
 	geoIPClient   *GeoIPClient
+	scheduler     *SchedulerManager
 	restartSignal chan<- struct{}
 }
 
+// ProxyList возвращает прокси. Пароли скрыты; ?reveal=true показывает их operator и admin.
 func (h handler) ProxyList(c *gin.Context) {
+	reveal, ok := revealSecrets(c, RoleOperator)
+	if !ok {
+		return
+	}
 	var p Proxy
 	list, err := p.List(h.db)
 	if err != nil {
@@ -31,10 +36,14 @@ func (h handler) ProxyList(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !reveal {
+		list = maskProxies(list)
+	}
 	c.JSON(http.StatusOK, gin.H{"data": list})
 }
 
 type ProxyRequest struct {
+	Scheme   string `json:"scheme"`
 	Ip       string `json:"ip"`
 	Port     string `json:"port"`
 	Username string `json:"username"`
@@ -42,11 +51,17 @@ type ProxyRequest struct {
 	Contacts string `json:"contacts"`
 	Phone    string `json:"phone"`
 	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	// Интервал проверки IP в минутах (0 - интервал тега или глобальный)
+	CheckInterval int `json:"checkInterval"`
+	// Цель доступности в процентах (0 - цель тега)
+	SLATarget float64 `json:"slaTarget"`
 }
 
 // createAndCheckProxy - вспомогательная функция для создания и проверки прокси
 func (h handler) createAndCheckProxy(p *Proxy) error {
-	latency, err := Ping(h.settings, p)
+	stg := h.scheduler.Settings()
+	latency, err := Ping(stg, p)
 	if err != nil {
 		log.Printf("Ping failed for proxy %s:%s - %v", p.Ip, p.Port, err)
 		p.LastStatus = 2 // 2 - failed
@@ -58,14 +73,12 @@ func (h handler) createAndCheckProxy(p *Proxy) error {
 		p.Failures = 0
 	}
 
-	realIp, realCountry, realOperator, err := RealIp(h.settings, p, h.db, h.geoIPClient)
+	exitInfo, err := RealIp(stg, p, h.db, h.geoIPClient)
 	if err != nil {
 		log.Printf("Failed to get real IP for proxy %s:%s - %v", p.Ip, p.Port, err)
 	}
 
-	p.RealIP = realIp
-	p.RealCountry = realCountry
-	p.Operator = realOperator
+	p.applyExitInfo(exitInfo)
 
 	return p.Save(h.db)
 }
@@ -78,8 +91,19 @@ func (h handler) CreateProxy(c *gin.Context) {
 		return
 	}
 
+	scheme, err := normalizeScheme(req.Scheme)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateSLATarget(req.SLATarget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	p := Proxy{
 		Id:       uuid.NewString(),
+		Scheme:   scheme,
 		Ip:       req.Ip,
 		Port:     req.Port,
 		Username: req.Username,
@@ -87,14 +111,20 @@ func (h handler) CreateProxy(c *gin.Context) {
 		Contacts: req.Contacts,
 		Phone:    req.Phone,
 		Name:     req.Name,
+		Tag:      req.Tag,
+
+		CheckInterval: req.CheckInterval,
+		SLATarget:     req.SLATarget,
 	}
-	err := h.createAndCheckProxy(&p)
+	err = h.createAndCheckProxy(&p)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": p})
+	h.audit(c, auditEntry{Action: AuditCreate, EntityType: AuditProxy, EntityID: p.Id, EntityName: p.Name, After: p})
+	h.scheduler.Reschedule()
+	c.JSON(http.StatusOK, gin.H{"data": p.Masked()})
 
 }
 
@@ -107,6 +137,16 @@ func (h handler) UpdateProxy(c *gin.Context) {
 		return
 	}
 
+	scheme, err := normalizeScheme(req.Scheme)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateSLATarget(req.SLATarget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var p Proxy
 	if err := h.db.First(&p, "id = ?", id).Error; err != nil {
 		log.Println(err)
@@ -114,22 +154,42 @@ func (h handler) UpdateProxy(c *gin.Context) {
 		return
 	}
 
+	before := p
+
 	// Обновляем поля
+	p.Scheme = scheme
 	p.Ip = req.Ip
 	p.Port = req.Port
 	p.Username = req.Username
-	p.Password = req.Password
+	p.Password = keepSecret(req.Password, p.Password)
 	p.Contacts = req.Contacts
 	p.Phone = req.Phone
 	p.Name = req.Name
+	p.Tag = req.Tag
+	p.CheckInterval = req.CheckInterval
+	p.SLATarget = req.SLATarget
 
 	if err := p.Save(h.db); err != nil {
 		log.Printf("Failed to save updated proxy %s:%s - %v", p.Ip, p.Port, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save proxy"})
 		return
 	}
+	h.audit(c, auditEntry{Action: AuditUpdate, EntityType: AuditProxy, EntityID: p.Id, EntityName: p.Name, Before: before, After: p})
+	h.scheduler.Reschedule()
+
+	c.JSON(http.StatusOK, gin.H{"data": p.Masked()})
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": p})
+// ProxyPassword возвращает пароль одного прокси в открытом виде - для
+// показа и копирования в списке, который загружается со скрытыми паролями.
+func (h handler) ProxyPassword(c *gin.Context) {
+	var p Proxy
+	if err := h.db.First(&p, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+	h.audit(c, auditEntry{Action: AuditReveal, EntityType: AuditProxy, EntityID: p.Id, EntityName: p.Name, Details: "password"})
+	c.JSON(http.StatusOK, gin.H{"data": p.Password})
 }
 
 func (h handler) Verify(c *gin.Context) {
@@ -141,13 +201,16 @@ func (h handler) Verify(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
 		return
 	}
-	latency, err := Ping(h.settings, &p)
+	before := p
+	stg := h.scheduler.Settings()
+	probes, err := RunProbes(c.Request.Context(), stg, &p)
+	latency := probeLatency(probes)
 	if err != nil {
 		log.Println(err)
 		p.LastStatus = 2
 		p.Failures += 1
 	}
-	speed, upload, err := CheckSpeed(h.settings, &p, h.db)
+	speed, upload, err := CheckSpeed(stg, &p, h.db)
 	if err != nil {
 		log.Println(err)
 	} else {
@@ -157,7 +220,7 @@ func (h handler) Verify(c *gin.Context) {
 	p.Upload = int(upload)
 	p.LastLatency = latency
 
-	realIp, realCountry, realOperator, err := RealIp(h.settings, &p, h.db, h.geoIPClient)
+	exitInfo, err := RealIp(stg, &p, h.db, h.geoIPClient)
 
 	if err != nil {
 		log.Println(err);
@@ -165,9 +228,7 @@ func (h handler) Verify(c *gin.Context) {
 		return;
 	}
 
-	p.RealIP = realIp
-	p.RealCountry = realCountry
-	p.Operator = realOperator
+	p.applyExitInfo(exitInfo)
 
 
 	err = p.Save(h.db)
@@ -176,7 +237,8 @@ func (h handler) Verify(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": p})
+	h.audit(c, auditEntry{Action: AuditVerify, EntityType: AuditProxy, EntityID: p.Id, EntityName: p.Name, Before: before, After: p})
+	c.JSON(http.StatusOK, gin.H{"data": p.Masked(), "probes": probes})
 }
 
 func (h handler) VerifyBatch(c *gin.Context) {
@@ -202,6 +264,8 @@ func (h handler) VerifyBatch(c *gin.Context) {
 	w.Write([]byte(": ping\n\n"))
 	flusher.Flush()
 
+	stg := h.scheduler.Settings()
+	verified := 0
 	for i, id := range ids {
 		id = strings.TrimSpace(id)
 
@@ -217,26 +281,25 @@ func (h handler) VerifyBatch(c *gin.Context) {
 		log.Printf("✅ START for ID: %s", id)
 
 		// Ваши проверки...
-		latency, _ := Ping(h.settings, &p)
+		latency, _ := Ping(stg, &p)
 		p.LastLatency = latency
-		speed, upload, _ := CheckSpeed(h.settings, &p, h.db)
+		speed, upload, _ := CheckSpeed(stg, &p, h.db)
 		p.Speed = int(speed)
 		p.Upload = int(upload)
-		realIp, realCountry, realOperator, err := RealIp(h.settings, &p, h.db, h.geoIPClient)
+		exitInfo, err := RealIp(stg, &p, h.db, h.geoIPClient)
 
 		if err != nil {
 			log.Println(err);
 			continue;
 		}
 
-		p.RealIP = realIp
-		p.RealCountry = realCountry
-		p.Operator = realOperator
+		p.applyExitInfo(exitInfo)
 
 		p.Save(h.db)
+		verified++
 
 		// PROGRESS
-		progressJSON, err := json.Marshal(p)
+		progressJSON, err := json.Marshal(p.Masked())
 		if err != nil {
 			log.Println("failed to marshal proxy:", err)
 		} else {
@@ -246,6 +309,10 @@ func (h handler) VerifyBatch(c *gin.Context) {
 		log.Printf("✅ PROGRESS for ID: %s", id)
 	}
 
+	// Одно событие на всю пачку, а не по записи на прокси
+	h.audit(c, auditEntry{Action: AuditVerify, EntityType: AuditProxy, EntityID: strings.Join(ids, ","),
+		Details: fmt.Sprintf("Batch verify: %d of %d proxies checked", verified, len(ids))})
+
 	// COMPLETE
 	completeJSON, _ := json.Marshal(gin.H{"message": "done", "total": len(ids)})
 	w.Write([]byte(fmt.Sprintf("event:complete\ndata:%s\n\n", completeJSON)))
@@ -290,7 +357,11 @@ func (h handler) ImportProxies(c *gin.Context) error {
 		}
 
 		p := Proxy{}
-		p.Parse(proxyLine)
+		if err := p.Parse(proxyLine); err != nil {
+			log.Printf("Failed to parse proxy line %q: %v", proxyLine, err)
+			failedLines++
+			continue
+		}
 
 		if proxyName != "" {
 			p.Name = proxyName
@@ -331,6 +402,7 @@ func (h handler) ImportProxies(c *gin.Context) error {
 
 	msg := fmt.Sprintf("Import finished. Imported: %d, Skipped: %d, Failed: %d",
 		importedCount, skippedDuplicates, failedLines)
+	h.audit(c, auditEntry{Action: AuditImport, EntityType: AuditProxy, EntityName: file.Filename, Details: msg})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       msg,
@@ -358,6 +430,9 @@ func (h handler) Delete(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.audit(c, auditEntry{Action: AuditDelete, EntityType: AuditProxy, EntityID: p.Id, EntityName: p.Name, Before: p})
+	h.scheduler.alerts.Forget(p.Id)
+	h.scheduler.Reschedule()
 	c.JSON(http.StatusOK, gin.H{"data": "Proxy deleted"})
 }
 
@@ -369,6 +444,8 @@ func (h handler) ExportAll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve proxies"})
 		return
 	}
+	// Экспорт выдает пароли прокси в открытом виде
+	h.audit(c, auditEntry{Action: AuditExport, EntityType: AuditProxy, Details: fmt.Sprintf("Exported %d proxies", len(list))})
 
 	c.Header("Content-Disposition", "attachment; filename=proxies.txt")
 	c.Header("Content-Type", "text/plain")
@@ -395,6 +472,7 @@ func (h handler) ExportSelected(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve selected proxies"})
 		return
 	}
+	h.audit(c, auditEntry{Action: AuditExport, EntityType: AuditProxy, EntityID: idsQuery, Details: fmt.Sprintf("Exported %d selected proxies", len(proxies))})
 
 	c.Header("Content-Disposition", "attachment; filename=selected_proxies.txt")
 	c.Header("Content-Type", "text/plain")
@@ -408,7 +486,12 @@ func (h handler) ExportSelected(c *gin.Context) {
 	}
 }
 
+// GetSettings возвращает настройки. Токены и пароли каналов скрыты, ?reveal=true показывает их.
 func (h handler) GetSettings(c *gin.Context) {
+	reveal, ok := revealSecrets(c, RoleAdmin)
+	if !ok {
+		return
+	}
 	var s Settings
 	settings, err := s.Get(h.db)
 	if err != nil {
@@ -416,6 +499,9 @@ func (h handler) GetSettings(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settings"})
 		return
 	}
+	if !reveal {
+		*settings = settings.Masked()
+	}
 	c.JSON(http.StatusOK, gin.H{"data": settings})
 }
 
@@ -427,6 +513,19 @@ func (h handler) UpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if err := validateProbeSet(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateReportSchedule(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Вместо скрытых секретов клиент присылает маску - оставляем сохраненные
+	before := h.scheduler.Settings()
+	req.keepSecrets(before)
+
 	// Сохраняем в базу данных
 	if err := req.Save(h.db); err != nil {
 		log.Println("Error saving settings:", err)
@@ -434,13 +533,27 @@ func (h handler) UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	// Обновляем настройки в текущем экземпляре обработчика
-	// Это полезно для немедленного ответа, но главное - последующий перезапуск
-	*h.settings = req
+	h.audit(c, auditEntry{Action: AuditUpdate, EntityType: AuditSettings, Before: before, After: req})
+
+	// Применяем настройки к планировщикам без перезапуска приложения
+	h.scheduler.UpdateSettings(req)
 
-	c.JSON(http.StatusOK, gin.H{"data": h.settings})
+	c.JSON(http.StatusOK, gin.H{"data": h.scheduler.Settings().Masked()})
+}
 
-	// // Отправляем сигнал на перезапуск приложения
+func (h handler) GeoIPStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.geoIPClient.Status()})
+}
+
+func (h handler) ReloadGeoIP(c *gin.Context) {
+	err := h.geoIPClient.Reload()
+	h.audit(c, auditEntry{Action: AuditReload, EntityType: AuditGeoIP, Failed: err != nil})
+	if err != nil {
+		log.Println("Error reloading GeoIP databases:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "data": h.geoIPClient.Status()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": h.geoIPClient.Status()})
 }
 
 func (h handler) GetSpeedLogs(c *gin.Context) {
@@ -697,6 +810,7 @@ func (h handler) GetFailureLogs(c *gin.Context) {
 	})
 }
 
+// GetFailureStats возвращает статистику ошибок прокси за days дней или период start/end.
 func (h handler) GetFailureStats(c *gin.Context) {
 	proxyId := c.Param("id")
 	if proxyId == "" {
@@ -704,10 +818,45 @@ func (h handler) GetFailureStats(c *gin.Context) {
 		return
 	}
 
-	var failureLog ProxyFailureLog
-	stats, err := GetFailureStats(h.db, proxyId, failureLog.Timestamp.Day())
+	var p Proxy
+	if err := p.Get(h.db, proxyId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+	h.failureStats(c, []Proxy{p}, false)
+}
+
+// GetFleetFailureStats возвращает статистику ошибок всех прокси или прокси с тегом tag.
+func (h handler) GetFleetFailureStats(c *gin.Context) {
+	var proxies []Proxy
+	query := h.db
+	tag := c.Query("tag")
+	if tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	if err := query.Find(&proxies).Error; err != nil {
+		log.Println("Error fetching proxies for failure stats:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve proxies"})
+		return
+	}
+	h.failureStats(c, proxies, tag == "")
+}
+
+func (h handler) failureStats(c *gin.Context, proxies []Proxy, fleet bool) {
+	days := c.DefaultQuery("days", "7")
+	if _, err := strconv.Atoi(days); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+	from, to, err := parseWindow(days+"d", c.Query("start"), c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := GetFailureStats(h.db, proxies, fleet, from, to, reportLocation(h.scheduler.Settings()))
 	if err != nil {
-		log.Printf("Error fetching failure stats for proxy %s: %v", proxyId, err)
+		log.Printf("Error fetching failure stats: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve failure statistics"})
 		return
 	}
@@ -727,21 +876,17 @@ func (h handler) TestNotification(c *gin.Context) {
 	}
 
 	// Create notification service from current settings
-	notifier := NewNotificationService(
-		h.settings.TelegramEnabled,
-		h.settings.TelegramToken,
-		h.settings.TelegramChatID,
-	)
-
-	message := fmt.Sprintf(
-		"🧪 <b>Test Notification</b>\n\n"+
-			"<b>Message:</b> %s\n"+
-			"<b>Time:</b> %s",
-		req.Message,
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-
-	if err := notifier.SendTelegram(message); err != nil {
+	stg := h.scheduler.Settings()
+	notifier := NewNotificationService(stg)
+	if len(notifier.Channels()) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No notification channels are enabled"})
+		return
+	}
+
+	event := Event{Type: EventTest, Message: req.Message, Timestamp: time.Now()}
+	err := notifier.Send(c.Request.Context(), event)
+	h.audit(c, auditEntry{Action: AuditTest, EntityType: AuditNotifier, Details: req.Message, Failed: err != nil})
+	if err != nil {
 		log.Printf("Failed to send test notification: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to send notification: %v", err)})
 		return
@@ -749,3 +894,662 @@ func (h handler) TestNotification(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Test notification sent successfully"})
 }
+
+func (h handler) GetTagSchedules(c *gin.Context) {
+	var t TagSchedule
+	schedules, err := t.List(h.db)
+	if err != nil {
+		log.Printf("Error fetching tag schedules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tag schedules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": schedules})
+}
+
+func (h handler) SaveTagSchedule(c *gin.Context) {
+	var req TagSchedule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Tag = c.Param("tag")
+	if req.CheckInterval < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checkInterval must not be negative"})
+		return
+	}
+	if err := validateSLATarget(req.SLATarget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before *TagSchedule
+	action := AuditCreate
+	var current TagSchedule
+	if err := h.db.First(&current, "tag = ?", req.Tag).Error; err == nil {
+		before, action = &current, AuditUpdate
+	}
+
+	if err := req.Save(h.db); err != nil {
+		log.Printf("Failed to save tag schedule %s: %v", req.Tag, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tag schedule"})
+		return
+	}
+	h.audit(c, auditEntry{Action: action, EntityType: AuditTagSchedule, EntityID: req.Tag, Before: before, After: req})
+	h.scheduler.Reschedule()
+
+	c.JSON(http.StatusOK, gin.H{"data": req})
+}
+
+func (h handler) DeleteTagSchedule(c *gin.Context) {
+	t := TagSchedule{Tag: c.Param("tag")}
+	var before TagSchedule
+	h.db.First(&before, "tag = ?", t.Tag)
+	if err := t.Delete(h.db); err != nil {
+		log.Printf("Failed to delete tag schedule %s: %v", t.Tag, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag schedule"})
+		return
+	}
+	h.audit(c, auditEntry{Action: AuditDelete, EntityType: AuditTagSchedule, EntityID: t.Tag, Before: before})
+	h.scheduler.Reschedule()
+
+	c.JSON(http.StatusOK, gin.H{"data": "Tag schedule deleted"})
+}
+
+func (h handler) SchedulerStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.scheduler.Status()})
+}
+
+// CheckerStatus возвращает состояние связи самого чекера (canary-проверки).
+func (h handler) CheckerStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.scheduler.CheckerStatus()})
+}
+
+// GetReport строит сводку за последние сутки или неделю без отправки.
+func (h handler) GetReport(c *gin.Context) {
+	summary, ok := h.buildReport(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// SendReport строит сводку и отправляет ее во все каналы уведомлений.
+func (h handler) SendReport(c *gin.Context) {
+	summary, ok := h.buildReport(c)
+	if !ok {
+		return
+	}
+	h.scheduler.notifier.NotifySummary(summary)
+	h.audit(c, auditEntry{Action: AuditSend, EntityType: AuditReport, EntityName: summary.Period})
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// buildReport строит сводку за период из ?period=daily|weekly. При ошибке
+// пишет ответ и возвращает ok=false.
+func (h handler) buildReport(c *gin.Context) (*SummaryData, bool) {
+	period := c.DefaultQuery("period", ReportDaily)
+	to := time.Now()
+	var from time.Time
+	switch period {
+	case ReportDaily:
+		from = to.AddDate(0, 0, -1)
+	case ReportWeekly:
+		from = to.AddDate(0, 0, -7)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be daily or weekly"})
+		return nil, false
+	}
+
+	summary, err := BuildSummary(h.db, period, from, to)
+	if err != nil {
+		log.Printf("Error building %s report: %v", period, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return nil, false
+	}
+	return summary, true
+}
+
+// GetAlerts возвращает алерты с фильтрами proxy_id, type и status (active, pending, open, resolved).
+func (h handler) GetAlerts(c *gin.Context) {
+	filters := AlertFilters{
+		ProxyID: c.Query("proxy_id"),
+		Type:    c.Query("type"),
+		Status:  c.Query("status"),
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+		return
+	}
+	filters.Page = page
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page size"})
+		return
+	}
+	filters.PageSize = pageSize
+
+	var alert Alert
+	alerts, total, err := alert.List(filters, h.db)
+	if err != nil {
+		log.Println("Error fetching alerts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  alerts,
+		"total": total,
+	})
+}
+
+// GetIncidents возвращает инциденты с фильтрами proxy_id, status и периодом start_date/end_date.
+func (h handler) GetIncidents(c *gin.Context) {
+	filters := IncidentFilters{
+		ProxyID: c.Query("proxy_id"),
+		Status:  c.Query("status"),
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+		return
+	}
+	filters.Page = page
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page size"})
+		return
+	}
+	filters.PageSize = pageSize
+
+	const layout = "2006-01-02"
+	if startDateStr := c.Query("start_date"); startDateStr != "" {
+		filters.StartDate, err = time.ParseInLocation(layout, startDateStr, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format. Use YYYY-MM-DD."})
+			return
+		}
+	}
+	if endDateStr := c.Query("end_date"); endDateStr != "" {
+		filters.EndDate, err = time.ParseInLocation(layout, endDateStr, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format. Use YYYY-MM-DD."})
+			return
+		}
+		filters.EndDate = filters.EndDate.AddDate(0, 0, 1) // включая весь день
+	}
+
+	var incident Incident
+	incidents, total, err := incident.List(filters, h.db)
+	if err != nil {
+		log.Println("Error fetching incidents:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve incidents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  incidents,
+		"total": total,
+	})
+}
+
+// GetIncident возвращает инцидент с его ошибками и разбивкой по типам.
+func (h handler) GetIncident(c *gin.Context) {
+	var incident Incident
+	if err := incident.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Incident not found"})
+		return
+	}
+
+	failures, err := incident.FailureLogs(h.db)
+	if err != nil {
+		log.Println("Error fetching incident failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve incident failures"})
+		return
+	}
+	counts, err := incident.ErrorCounts(h.db)
+	if err != nil {
+		log.Println("Error counting incident failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve incident failures"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"incident":     incident,
+		"failures":     failures,
+		"error_counts": counts,
+	}})
+}
+
+// GetAvailability возвращает доступность прокси за период window (24h, 7d, 30d)
+// или start/end. Фильтр tag ограничивает список прокси.
+func (h handler) GetAvailability(c *gin.Context) {
+	from, to, err := parseWindow(c.Query("window"), c.Query("start"), c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var proxies []Proxy
+	query := h.db
+	if tag := c.Query("tag"); tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	if err := query.Find(&proxies).Error; err != nil {
+		log.Println("Error fetching proxies for availability:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve proxies"})
+		return
+	}
+
+	list, err := ProxyAvailability(h.db, proxies, from, to)
+	if err != nil {
+		log.Println("Error computing availability:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute availability"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// GetProxyAvailability возвращает доступность одного прокси за период.
+func (h handler) GetProxyAvailability(c *gin.Context) {
+	from, to, err := parseWindow(c.Query("window"), c.Query("start"), c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var p Proxy
+	if err := p.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+
+	list, err := ProxyAvailability(h.db, []Proxy{p}, from, to)
+	if err != nil {
+		log.Println("Error computing availability:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute availability"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list[0]})
+}
+
+// GetDailyAvailability возвращает доступность прокси по дням для графиков.
+func (h handler) GetDailyAvailability(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 || days > maxAvailabilityDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxAvailabilityDays)})
+		return
+	}
+
+	var p Proxy
+	if err := p.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+
+	series, err := ProxyDailyAvailability(h.db, &p, days, reportLocation(h.scheduler.Settings()))
+	if err != nil {
+		log.Println("Error computing daily availability:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute availability"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": series})
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login проверяет пароль и открывает сессию в cookie.
+func (h handler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if wait := loginLimiter.Blocked(c.ClientIP(), req.Username); wait > 0 {
+		log.Printf("Auth: login for %q from %s throttled", req.Username, c.ClientIP())
+		retryAfter(c, wait)
+		return
+	}
+
+	user, err := Authenticate(h.db, req.Username, req.Password)
+	if err != nil {
+		if err != ErrInvalidCredentials {
+			log.Println("Error authenticating user:", err)
+		} else {
+			log.Printf("Auth: failed login for %q from %s", req.Username, c.ClientIP())
+			loginLimiter.Failed(c.ClientIP(), req.Username)
+		}
+		h.auditAs(c, req.Username, auditEntry{Action: AuditLogin, EntityType: AuditUser, EntityName: req.Username, Failed: true})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidCredentials.Error()})
+		return
+	}
+
+	loginLimiter.Succeeded(c.ClientIP(), req.Username)
+
+	token, expires, err := CreateSession(h.db, user)
+	if err != nil {
+		log.Println("Error creating session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	setSessionCookie(c, token, int(time.Until(expires).Seconds()))
+	h.auditAs(c, user.Username, auditEntry{Action: AuditLogin, EntityType: AuditUser, EntityID: user.ID, EntityName: user.Username})
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// Logout завершает текущую сессию.
+func (h handler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+		if err := DeleteSession(h.db, token); err != nil {
+			log.Println("Error deleting session:", err)
+		}
+	}
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"data": "Logged out"})
+}
+
+// CurrentUser возвращает вошедшего пользователя.
+func (h handler) CurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": currentUser(c)})
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// ChangePassword меняет пароль вошедшего пользователя и завершает его
+// остальные сессии.
+func (h handler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if !user.CheckPassword(req.CurrentPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if err := user.SetPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := user.Save(h.db); err != nil {
+		log.Println("Error saving user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save password"})
+		return
+	}
+	token, _ := c.Cookie(sessionCookie)
+	if err := DeleteUserSessions(h.db, user.ID, token); err != nil {
+		log.Println("Error deleting sessions:", err)
+	}
+	h.audit(c, auditEntry{Action: AuditUpdate, EntityType: AuditUser, EntityID: user.ID, EntityName: user.Username, Details: "Password changed"})
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // при изменении пустой - не менять
+	Role     string `json:"role"`
+}
+
+func (h handler) ListUsers(c *gin.Context) {
+	users, err := ListUsers(h.db)
+	if err != nil {
+		log.Println("Error fetching users:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+func (h handler) CreateUser(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+		return
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be admin, operator or viewer"})
+		return
+	}
+
+	var exists int64
+	h.db.Model(&User{}).Where("username = ?", req.Username).Count(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
+
+	user := User{ID: uuid.NewString(), Username: req.Username, Role: req.Role, CreatedAt: time.Now()}
+	if err := user.SetPassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := user.Save(h.db); err != nil {
+		log.Println("Error creating user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	h.audit(c, auditEntry{Action: AuditCreate, EntityType: AuditUser, EntityID: user.ID, EntityName: user.Username, After: user})
+	c.JSON(http.StatusCreated, gin.H{"data": user})
+}
+
+// UpdateUser меняет роль и/или пароль пользователя. Смена пароля
+// завершает все его сессии.
+func (h handler) UpdateUser(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := user.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	before := user
+
+	if req.Role != "" && req.Role != user.Role {
+		if !validRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be admin, operator or viewer"})
+			return
+		}
+		if err := ensureAdminRemains(h.db, &user, req.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.Role = req.Role
+	}
+	if req.Password != "" {
+		if err := user.SetPassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := user.Save(h.db); err != nil {
+		log.Println("Error updating user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	details := ""
+	if req.Password != "" {
+		if err := DeleteUserSessions(h.db, user.ID, ""); err != nil {
+			log.Println("Error deleting sessions:", err)
+		}
+		// Хэш пароля не сериализуется, поэтому в diff его нет
+		details = "Password changed"
+	}
+	h.audit(c, auditEntry{Action: AuditUpdate, EntityType: AuditUser, EntityID: user.ID, EntityName: user.Username, Before: before, After: user, Details: details})
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+func (h handler) DeleteUser(c *gin.Context) {
+	var user User
+	if err := user.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ID == currentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+	if err := DeleteUser(h.db, &user); err != nil {
+		if err == ErrLastAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Println("Error deleting user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	h.audit(c, auditEntry{Action: AuditDelete, EntityType: AuditUser, EntityID: user.ID, EntityName: user.Username, Before: user})
+	c.JSON(http.StatusOK, gin.H{"data": "User deleted"})
+}
+
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 - бессрочный
+}
+
+func (h handler) ListAPITokens(c *gin.Context) {
+	tokens, err := ListAPITokens(h.db)
+	if err != nil {
+		log.Println("Error fetching API tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tokens, "scopes": tokenScopes})
+}
+
+// CreateAPIToken создает токен. Значение токена возвращается только в этом ответе.
+func (h handler) CreateAPIToken(c *gin.Context) {
+	var req APITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q, expected one of %s", scope, strings.Join(tokenScopes, ", "))})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInDays must not be negative"})
+		return
+	}
+
+	t := APIToken{Name: req.Name, Scopes: req.Scopes, CreatedBy: currentUser(c).Username}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		t.ExpiresAt = &expires
+	}
+	token, err := CreateAPIToken(h.db, &t)
+	if err != nil {
+		log.Println("Error creating API token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API token"})
+		return
+	}
+	h.audit(c, auditEntry{Action: AuditCreate, EntityType: AuditToken, EntityID: t.ID, EntityName: t.Name, After: t})
+	c.JSON(http.StatusCreated, gin.H{"data": t, "token": token})
+}
+
+// RevokeAPIToken отзывает токен.
+func (h handler) RevokeAPIToken(c *gin.Context) {
+	var t APIToken
+	if err := t.Get(h.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+		return
+	}
+	if t.RevokedAt == nil {
+		if err := RevokeAPIToken(h.db, &t); err != nil {
+			log.Println("Error revoking API token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API token"})
+			return
+		}
+		h.audit(c, auditEntry{Action: AuditRevoke, EntityType: AuditToken, EntityID: t.ID, EntityName: t.Name})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": t})
+}
+
+// GetAuditEvents возвращает журнал аудита с фильтрами и пагинацией.
+// Даты start_date и end_date - YYYY-MM-DD, end_date включительно.
+func (h handler) GetAuditEvents(c *gin.Context) {
+	filters := AuditFilters{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+	}
+	if start := c.Query("start_date"); start != "" {
+		t, err := time.ParseInLocation("2006-01-02", start, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+			return
+		}
+		filters.StartDate = t
+	}
+	if end := c.Query("end_date"); end != "" {
+		t, err := time.ParseInLocation("2006-01-02", end, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+			return
+		}
+		filters.EndDate = t.AddDate(0, 0, 1)
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+		return
+	}
+	filters.Page = page
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil || pageSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page size"})
+		return
+	}
+	filters.PageSize = pageSize
+
+	var event AuditEvent
+	events, total, err := event.List(filters, h.db)
+	if err != nil {
+		log.Println("Error fetching audit events:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  events,
+		"total": total,
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Состояния инцидента
+const (
+	IncidentOpen   = "open"
+	IncidentClosed = "closed"
+)
+
+// Incident - период недоступности прокси: открывается, когда прокси признан
+// мертвым, закрывается при восстановлении. Строки ProxyFailureLog периода
+// ссылаются на инцидент через IncidentID.
+type Incident struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	ProxyID     string    `json:"proxy_id" gorm:"index"`
+	Status      string    `json:"status" gorm:"index"`
+	StartedAt   time.Time `json:"started_at" gorm:"index"` // первая ошибка серии
+	EndedAt     time.Time `json:"ended_at"`
+	Duration    int64     `json:"duration"` // секунды; у открытого - по текущий момент
+	Failures    int       `json:"failures"`
+	RootError   string    `json:"root_error"`                         // тип первой ошибки серии
+	RootMessage string    `json:"root_message"`                       // текст первой ошибки
+	ErrorTypes  []string  `json:"error_types" gorm:"serializer:json"` // типы ошибок, частые первыми
+	LastError   string    `json:"last_error"`
+
+	TypeCounts map[string]int `json:"-" gorm:"serializer:json"` // число ошибок по типам для ErrorTypes
+}
+
+// IncidentFilters - фильтры списка инцидентов. Период выбирает инциденты,
+// пересекающиеся с [StartDate, EndDate].
+type IncidentFilters struct {
+	ProxyID   string
+	Status    string
+	StartDate time.Time
+	EndDate   time.Time
+	Page      int
+	PageSize  int
+}
+
+// IncidentErrorCount - число ошибок одного типа в инциденте.
+type IncidentErrorCount struct {
+	ErrorType string `json:"error_type"`
+	Count     int    `json:"count"`
+}
+
+func (Incident) TableName() string {
+	return "incidents"
+}
+
+func (i *Incident) Save(db *gorm.DB) error {
+	return db.Save(i).Error
+}
+
+// Get загружает инцидент по ID.
+func (i *Incident) Get(db *gorm.DB, id string) error {
+	if err := db.First(i, "id = ?", id).Error; err != nil {
+		return err
+	}
+	i.fillDuration(time.Now())
+	return nil
+}
+
+// List возвращает инциденты с пагинацией, новые первыми.
+func (i *Incident) List(filters IncidentFilters, db *gorm.DB) ([]Incident, int64, error) {
+	incidents := []Incident{}
+	query := db.Model(i)
+	if filters.ProxyID != "" {
+		query = query.Where("proxy_id = ?", filters.ProxyID)
+	}
+	if filters.Status != "" {
+		query = query.Where("status = ?", filters.Status)
+	}
+	if !filters.EndDate.IsZero() {
+		query = query.Where("started_at < ?", filters.EndDate)
+	}
+	if !filters.StartDate.IsZero() {
+		query = query.Where("status = ? OR ended_at > ?", IncidentOpen, filters.StartDate)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return incidents, 0, err
+	}
+
+	offset := 0
+	if filters.Page > 1 {
+		offset = filters.PageSize * (filters.Page - 1)
+	}
+	err := query.Order("started_at desc").Limit(filters.PageSize).Offset(offset).Find(&incidents).Error
+	now := time.Now()
+	for k := range incidents {
+		incidents[k].fillDuration(now)
+	}
+	return incidents, count, err
+}
+
+// FailureLogs возвращает строки ProxyFailureLog инцидента по времени.
+func (i *Incident) FailureLogs(db *gorm.DB) ([]ProxyFailureLog, error) {
+	logs := []ProxyFailureLog{}
+	err := db.Where("incident_id = ?", i.ID).Order("timestamp").Find(&logs).Error
+	return logs, err
+}
+
+// ErrorCounts возвращает число ошибок инцидента по типам, частые первыми.
+func (i *Incident) ErrorCounts(db *gorm.DB) ([]IncidentErrorCount, error) {
+	counts := []IncidentErrorCount{}
+	err := db.Model(&ProxyFailureLog{}).
+		Select("error_type, COUNT(*) AS count").
+		Where("incident_id = ?", i.ID).
+		Group("error_type").
+		Order("count DESC").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// fillDuration считает длительность открытого инцидента по текущий момент.
+func (i *Incident) fillDuration(now time.Time) {
+	if i.Status == IncidentOpen {
+		i.Duration = int64(now.Sub(i.StartedAt).Seconds())
+	}
+}
+
+// refresh привязывает к инциденту новые ошибки прокси после since и
+// добавляет их к сводке. Ошибки до признания прокси мертвым (с последней
+// успешной проверки) тоже относятся к инциденту. Уже привязанные строки
+// не перечитываются.
+func (i *Incident) refresh(db *gorm.DB, since time.Time) error {
+	logs := []ProxyFailureLog{}
+	if err := db.Where("proxy_id = ? AND (incident_id = '' OR incident_id IS NULL) AND timestamp > ?", i.ProxyID, since).
+		Order("timestamp").
+		Find(&logs).Error; err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(logs))
+	for k, l := range logs {
+		ids[k] = l.ID
+	}
+	if err := db.Model(&ProxyFailureLog{}).Where("id IN ?", ids).Update("incident_id", i.ID).Error; err != nil {
+		return err
+	}
+
+	// Инциденты, открытые до появления TypeCounts, пересчитываются один раз
+	if i.TypeCounts == nil && i.Failures > 0 {
+		counts, err := i.ErrorCounts(db)
+		if err != nil {
+			return err
+		}
+		i.TypeCounts = make(map[string]int, len(counts))
+		for _, c := range counts {
+			i.TypeCounts[c.ErrorType] = c.Count
+		}
+	}
+	if i.TypeCounts == nil {
+		i.TypeCounts = make(map[string]int)
+	}
+
+	first, last := logs[0], logs[len(logs)-1]
+	if i.Failures == 0 || first.Timestamp.Before(i.StartedAt) {
+		i.RootError, i.RootMessage = first.ErrorType, first.ErrorMsg
+	}
+	if first.Timestamp.Before(i.StartedAt) {
+		i.StartedAt = first.Timestamp
+	}
+	i.LastError = last.ErrorMsg
+	i.Failures += len(logs)
+	for _, l := range logs {
+		i.TypeCounts[l.ErrorType]++
+	}
+
+	i.ErrorTypes = make([]string, 0, len(i.TypeCounts))
+	for t := range i.TypeCounts {
+		i.ErrorTypes = append(i.ErrorTypes, t)
+	}
+	sort.Slice(i.ErrorTypes, func(a, b int) bool {
+		ca, cb := i.TypeCounts[i.ErrorTypes[a]], i.TypeCounts[i.ErrorTypes[b]]
+		if ca != cb {
+			return ca > cb
+		}
+		return i.ErrorTypes[a] < i.ErrorTypes[b]
+	})
+	return nil
+}
+
+// incidentSince возвращает начало серии ошибок, с которой открывается новый
+// инцидент: последнюю успешную проверку или конец предыдущего инцидента.
+// Если прокси ни разу не проходил проверку, серия - его последние
+// p.Failures ошибок ping_failed, а не вся история.
+func incidentSince(db *gorm.DB, p *Proxy) (time.Time, error) {
+	since := p.LastCheck
+
+	var last []Incident
+	if err := db.Select("ended_at").
+		Where("proxy_id = ? AND status = ?", p.Id, IncidentClosed).
+		Order("ended_at desc").
+		Limit(1).
+		Find(&last).Error; err != nil {
+		return since, err
+	}
+	if len(last) > 0 && last[0].EndedAt.After(since) {
+		since = last[0].EndedAt
+	}
+	if !since.IsZero() || p.Failures <= 0 {
+		return since, nil
+	}
+
+	var streak []ProxyFailureLog
+	if err := db.Select("timestamp").
+		Where("proxy_id = ? AND error_type = ?", p.Id, "ping_failed").
+		Order("timestamp desc").
+		Limit(p.Failures).
+		Find(&streak).Error; err != nil {
+		return since, err
+	}
+	if len(streak) > 0 {
+		// refresh берет строки строго после since
+		since = streak[len(streak)-1].Timestamp.Add(-time.Nanosecond)
+	}
+	return since, nil
+}
+
+// openIncident возвращает открытый инцидент прокси или nil.
+func openIncident(db *gorm.DB, proxyID string) (*Incident, error) {
+	var incidents []Incident
+	err := db.Where("proxy_id = ? AND status = ?", proxyID, IncidentOpen).
+		Order("started_at").
+		Limit(1).
+		Find(&incidents).Error
+	if err != nil || len(incidents) == 0 {
+		return nil, err
+	}
+	return &incidents[0], nil
+}
+
+// TrackIncident вызывается после каждой ошибки мертвого прокси: открывает
+// инцидент, если его еще нет, и привязывает к нему новые строки ProxyFailureLog.
+func TrackIncident(db *gorm.DB, p *Proxy) error {
+	incident, err := openIncident(db, p.Id)
+	if err != nil {
+		return err
+	}
+	since := time.Time{}
+	if incident == nil {
+		incident = &Incident{
+			ID:        uuid.NewString(),
+			ProxyID:   p.Id,
+			Status:    IncidentOpen,
+			StartedAt: time.Now(),
+		}
+		if since, err = incidentSince(db, p); err != nil {
+			return err
+		}
+	} else {
+		since = incident.StartedAt
+	}
+	if err := incident.refresh(db, since); err != nil {
+		return err
+	}
+	return incident.Save(db)
+}
+
+// CloseIncident закрывает открытый инцидент прокси после успешной проверки.
+func CloseIncident(db *gorm.DB, p *Proxy) error {
+	incident, err := openIncident(db, p.Id)
+	if err != nil || incident == nil {
+		return err
+	}
+	if err := incident.refresh(db, incident.StartedAt); err != nil {
+		return err
+	}
+	now := time.Now()
+	incident.Status = IncidentClosed
+	incident.EndedAt = now
+	incident.Duration = int64(now.Sub(incident.StartedAt).Seconds())
+	return incident.Save(db)
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net"
 	"strings"
@@ -17,28 +17,47 @@ type IP struct {
 	Cc      string `json:"cc"`
 }
 
-func RealIp(stg *Settings, proxy *Proxy, db *gorm.DB, geoIPClient *GeoIPClient) (string, string, string, error) {
+// ExitInfo - внешний IP прокси и данные о сети из GeoIP.
+type ExitInfo struct {
+	Ip           string
+	Country      string
+	CountryCode  string
+	City         string
+	ISP          string
+	Organization string
+	ASN          uint
+	MCC          string
+	MNC          string
+}
+
+// applyExitInfo переносит данные о выходном IP в поля прокси.
+func (p *Proxy) applyExitInfo(info ExitInfo) {
+	p.RealIP = info.Ip
+	p.RealCountry = info.Country
+	p.RealCountryCode = info.CountryCode
+	p.RealCity = info.City
+	p.Operator = info.ISP
+	p.Organization = info.Organization
+	p.ASN = info.ASN
+	p.MCC = info.MCC
+	p.MNC = info.MNC
+}
+
+func RealIp(stg *Settings, proxy *Proxy, db *gorm.DB, geoIPClient *GeoIPClient) (ExitInfo, error) {
 	client, err := newProxyClient(proxy, stg)
 	if err != nil {
 		log.Printf("Error creating proxy client for %s:%s - %v", proxy.Ip, proxy.Port, err)
-		return "", "", "", err
+		return ExitInfo{}, err
 	}
 
-	rsp, err := client.Get("https://api.myip.com")
+	ip, err := resolveExitIP(client, stg)
 	if err != nil {
 		log.Printf("Error getting real IP for %s:%s - %v", proxy.Ip, proxy.Port, err)
-		return "", "", "", err
-	}
-	defer rsp.Body.Close()
-
-	ip := &IP{}
-	if err := json.NewDecoder(rsp.Body).Decode(ip); err != nil {
-		log.Printf("Error decoding IP response for %s:%s - %v", proxy.Ip, proxy.Port, err)
-		return "", "", "", err
+		return ExitInfo{}, err
 	}
 
 	operator, err := geoIPClient.ReadData(ip.Ip)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrGeoIPUnavailable) {
 		log.Printf("Error reading geoIP data for %s - %v", ip.Ip, err)
 		// Continue with empty operator instead of failing
 	}
@@ -47,6 +66,23 @@ func RealIp(stg *Settings, proxy *Proxy, db *gorm.DB, geoIPClient *GeoIPClient)
 		op = "Moldtelecom"
 	}
 
+	info := ExitInfo{
+		Ip:           ip.Ip,
+		Country:      operator.Country,
+		CountryCode:  operator.CountryCode,
+		City:         operator.City,
+		ISP:          op,
+		Organization: operator.Organization,
+		ASN:          operator.ASN,
+		MCC:          operator.MCC,
+		MNC:          operator.MNC,
+	}
+	// Без Country/City базы страну берем из ответа IP-echo сервиса
+	if info.Country == "" {
+		info.Country = ip.Country
+		info.CountryCode = ip.Cc
+	}
+
 	// Get last IP log entry
 	var pIpLog ProxyIPLog
 	lastLog, err := pIpLog.LastByTimestamp(proxy.Id, db)
@@ -56,7 +92,7 @@ func RealIp(stg *Settings, proxy *Proxy, db *gorm.DB, geoIPClient *GeoIPClient)
 
 	// Detect if IP is stuck (not changed for more than 12 hours)
 	stack := false
-	if lastLog != nil && lastLog.Ip == ip.Ip && time.Since(lastLog.Timestamp) > 12*time.Hour {
+	if lastLog != nil && lastLog.Ip == ip.Ip && time.Since(lastLog.Timestamp) > stuckIPThreshold {
 		stack = true
 		log.Printf("Warning: IP stuck for proxy %s:%s - Same IP %s for >12 hours", proxy.Ip, proxy.Port, ip.Ip)
 	}
@@ -69,47 +105,55 @@ func RealIp(stg *Settings, proxy *Proxy, db *gorm.DB, geoIPClient *GeoIPClient)
 		proxy.LastIPChange = time.Now()
 		proxy.Stack = false // IP changed, so not stuck anymore
 
-		hist := ProxyIPLog{
-			Id:         uuid.NewString(),
-			ProxyId:    proxy.Id,
-			Timestamp:  time.Now(),
-			Ip:         ip.Ip,
-			OldIp:      lastLog.Ip,
-			Country:    ip.Country,
-			OldCountry: lastLog.Country,
-			ISP:        op,
-			OldISP:     lastLog.ISP,
-			Stack:      false, // IP changed, so not stuck
-		}
+		hist := newProxyIPLog(proxy.Id, info)
+		hist.OldIp = lastLog.Ip
+		hist.OldCountry = lastLog.Country
+		hist.OldISP = lastLog.ISP
+		hist.OldCity = lastLog.City
+		hist.OldASN = lastLog.ASN
+		hist.OldMCC = lastLog.MCC
+		hist.OldMNC = lastLog.MNC
 		if err := hist.Save(db); err != nil {
 			log.Printf("Error saving IP log for proxy %s - %v", proxy.Id, err)
 		} else {
-			log.Printf("IP changed for proxy %s:%s: %s -> %s", proxy.Ip, proxy.Port, lastLog.Ip, ip.Ip)
+			log.Printf("IP changed for proxy %s:%s: %s -> %s (AS%d -> AS%d)", proxy.Ip, proxy.Port, lastLog.Ip, ip.Ip, lastLog.ASN, info.ASN)
 		}
 	} else if lastLog == nil && ip.Ip != "" {
 		// First time checking this proxy - create initial log
 		proxy.LastIPChange = time.Now()
 		proxy.Stack = false
-		hist := ProxyIPLog{
-			Id:         uuid.NewString(),
-			ProxyId:    proxy.Id,
-			Timestamp:  time.Now(),
-			Ip:         ip.Ip,
-			OldIp:      proxy.Ip,
-			Country:    ip.Country,
-			OldCountry: "",
-			ISP:        op,
-			OldISP:     "",
-			Stack:      false,
-		}
+		hist := newProxyIPLog(proxy.Id, info)
+		hist.OldIp = proxy.Ip
 		if err := hist.Save(db); err != nil {
 			log.Printf("Error saving initial IP log for proxy %s - %v", proxy.Id, err)
 		}
 	}
 
-	return ip.Ip, ip.Country, operator.ISP, nil
+	return info, nil
 }
 
+// newProxyIPLog создает запись истории IP для нового выходного адреса.
+func newProxyIPLog(proxyId string, info ExitInfo) ProxyIPLog {
+	return ProxyIPLog{
+		Id:           uuid.NewString(),
+		ProxyId:      proxyId,
+		Timestamp:    time.Now(),
+		Ip:           info.Ip,
+		Country:      info.Country,
+		CountryCode:  info.CountryCode,
+		City:         info.City,
+		ISP:          info.ISP,
+		Organization: info.Organization,
+		ASN:          info.ASN,
+		MCC:          info.MCC,
+		MNC:          info.MNC,
+		Stack:        false, // IP changed, so not stuck
+	}
+}
+
+// IP считается залипшим, если не менялся дольше этого времени
+const stuckIPThreshold = 12 * time.Hour
+
 func GetOutboundIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
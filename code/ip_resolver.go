@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Форматы ответа IP-echo сервисов
+const (
+	IPFormatJSON   = "json"
+	IPFormatText   = "text"
+	IPFormatHeader = "header"
+
+	// Ограничение на размер ответа IP-echo сервиса
+	maxIPResponseSize = 64 * 1024
+)
+
+// IPResolver описывает один IP-echo сервис. Field - путь к IP в JSON через точку
+// (например "data.ip") или имя заголовка для формата header. CountryField и CcField
+// опциональны и используются только для формата json.
+type IPResolver struct {
+	URL          string `json:"url"`
+	Format       string `json:"format"`
+	Field        string `json:"field"`
+	CountryField string `json:"countryField"`
+	CcField      string `json:"ccField"`
+}
+
+// defaultIPResolvers используется, если список в настройках пуст.
+var defaultIPResolvers = []IPResolver{
+	{URL: "https://api.myip.com", Format: IPFormatJSON, Field: "ip", CountryField: "country", CcField: "cc"},
+	{URL: "https://api.ipify.org?format=json", Format: IPFormatJSON, Field: "ip"},
+	{URL: "https://ifconfig.me/ip", Format: IPFormatText},
+}
+
+// resolveExitIP определяет внешний IP прокси, перебирая IP-echo сервисы по порядку.
+// Если включен IPConsensus, результат принимается только когда два сервиса
+// вернули один и тот же IP.
+func resolveExitIP(client *http.Client, stg *Settings) (*IP, error) {
+	resolvers := stg.IPResolvers
+	if len(resolvers) == 0 {
+		resolvers = defaultIPResolvers
+	}
+
+	var errs []error
+	seen := make(map[string]*IP)
+
+	for _, r := range resolvers {
+		ip, err := r.Resolve(client)
+		if err != nil {
+			log.Printf("IP resolver %s failed: %v", r.URL, err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.URL, err))
+			continue
+		}
+
+		if !stg.IPConsensus {
+			return ip, nil
+		}
+
+		if prev, ok := seen[ip.Ip]; ok {
+			// Страну берем у того сервиса, который ее вернул
+			if prev.Country == "" {
+				prev.Country, prev.Cc = ip.Country, ip.Cc
+			}
+			return prev, nil
+		}
+		seen[ip.Ip] = ip
+	}
+
+	if stg.IPConsensus && len(seen) > 0 {
+		ips := make([]string, 0, len(seen))
+		for ip := range seen {
+			ips = append(ips, ip)
+		}
+		errs = append(errs, fmt.Errorf("no two resolvers agreed on exit IP (got %s)", strings.Join(ips, ", ")))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no IP resolvers configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Resolve запрашивает сервис через client и извлекает IP из ответа.
+func (r IPResolver) Resolve(client *http.Client) (*IP, error) {
+	rsp, err := client.Get(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", rsp.StatusCode)
+	}
+
+	ip := &IP{}
+	switch strings.ToLower(r.Format) {
+	case IPFormatHeader:
+		ip.Ip = strings.TrimSpace(rsp.Header.Get(r.Field))
+	case IPFormatText, "":
+		body, err := io.ReadAll(io.LimitReader(rsp.Body, maxIPResponseSize))
+		if err != nil {
+			return nil, err
+		}
+		ip.Ip = strings.TrimSpace(string(body))
+	case IPFormatJSON:
+		var doc any
+		if err := json.NewDecoder(io.LimitReader(rsp.Body, maxIPResponseSize)).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		field := r.Field
+		if field == "" {
+			field = "ip"
+		}
+		ip.Ip = jsonPathString(doc, field)
+		if r.CountryField != "" {
+			ip.Country = jsonPathString(doc, r.CountryField)
+		}
+		if r.CcField != "" {
+			ip.Cc = jsonPathString(doc, r.CcField)
+		}
+	default:
+		return nil, fmt.Errorf("unknown response format %q", r.Format)
+	}
+
+	if net.ParseIP(ip.Ip) == nil {
+		return nil, fmt.Errorf("response does not contain a valid IP: %q", ip.Ip)
+	}
+	return ip, nil
+}
+
+// jsonPathString достает строковое значение из JSON по пути вида "a.b.0.c".
+func jsonPathString(doc any, path string) string {
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			cur = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return ""
+			}
+			cur = node[i]
+		default:
+			return ""
+		}
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
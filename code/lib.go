@@ -1,44 +1,138 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
+
+	xproxy "golang.org/x/net/proxy"
 )
 
 // newProxyClient создает и настраивает http.Client для работы через прокси.
+// Протокол берется из proxy.Scheme: http/https/socks5/socks5h обслуживаются
+// стандартным транспортом, для socks4 подключается собственный dialer.
 func newProxyClient(proxy *Proxy, stg *Settings) (*http.Client, error) {
-	// Формируем URL прокси с данными для аутентификации, если они есть.
-	proxyStr := fmt.Sprintf("http://%s:%s", proxy.Ip, proxy.Port)
-
-	if proxy.Username != "" {
-		proxyStr = fmt.Sprintf("http://%s:%s@%s:%s", proxy.Username, proxy.Password, proxy.Ip, proxy.Port)
-	}
-
-	proxyUrl, err := url.Parse(proxyStr)
+	scheme, err := normalizeScheme(proxy.Scheme)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		return nil, err
 	}
+	timeout := time.Duration(stg.Timeout) * time.Second
 
 	// Создаем транспорт с настройками прокси.
+	// Клиент создается на каждую проверку, поэтому keep-alive отключен,
+	// чтобы соединения не оставались висеть в пуле транспорта.
 	transport := &http.Transport{
-		Proxy:           http.ProxyURL(proxyUrl),
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: stg.SkipSSLVerify},
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: stg.SkipSSLVerify},
+		DisableKeepAlives: true,
+	}
+
+	switch scheme {
+	case SchemeSOCKS4:
+		dialer := &socks4Dialer{
+			proxyAddr: net.JoinHostPort(proxy.Ip, proxy.Port),
+			userID:    proxy.Username,
+			forward:   &net.Dialer{Timeout: timeout},
+		}
+		transport.DialContext = dialer.DialContext
+	default:
+		// Формируем URL прокси с данными для аутентификации, если они есть.
+		proxyUrl := proxy.URL()
+		if proxyUrl.Host == "" {
+			return nil, fmt.Errorf("failed to build proxy URL for %s:%s", proxy.Ip, proxy.Port)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
 	}
 
 	// Создаем HTTP-клиент.
 	client := &http.Client{
 		Transport: transport,
-		Timeout:   time.Duration(stg.Timeout) * time.Second,
+		Timeout:   timeout,
 	}
 
 	return client, nil
 }
 
+// dialThroughProxy открывает TCP-туннель до addr через прокси с учетом его протокола:
+// HTTP CONNECT для http/https, SOCKS-рукопожатие для socks4/socks5.
+func dialThroughProxy(ctx context.Context, proxy *Proxy, stg *Settings, addr string) (net.Conn, error) {
+	scheme, err := normalizeScheme(proxy.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := net.JoinHostPort(proxy.Ip, proxy.Port)
+	forward := &net.Dialer{Timeout: time.Duration(stg.Timeout) * time.Second}
+
+	switch scheme {
+	case SchemeSOCKS4:
+		dialer := &socks4Dialer{proxyAddr: proxyAddr, userID: proxy.Username, forward: forward}
+		return dialer.DialContext(ctx, "tcp", addr)
+	case SchemeSOCKS5, SchemeSOCKS5H:
+		var auth *xproxy.Auth
+		if proxy.Username != "" {
+			auth = &xproxy.Auth{User: proxy.Username, Password: proxy.Password}
+		}
+		dialer, err := xproxy.SOCKS5("tcp", proxyAddr, auth, forward)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(xproxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+
+	conn, err := forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == SchemeHTTPS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Ip, InsecureSkipVerify: stg.SkipSSLVerify})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.Username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(proxy.Username + ":" + proxy.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT returned status %d", resp.StatusCode)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
 func sliceStrToIntConvert(slice []string) []int {
 	var sliceNew []int
 	for _, v := range slice {
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения подбора пароля: после лимита неудачных попыток за окно
+// IP или имя пользователя блокируется на loginLockout. По имени лимит меньше,
+// чем по IP: за одним адресом (NAT, прокси) могут работать несколько человек.
+const (
+	loginFailureWindow   = 15 * time.Minute
+	loginLockout         = 15 * time.Minute
+	maxLoginFailuresUser = 5
+	maxLoginFailuresIP   = 20
+	maxLoginThrottleKeys = 10000 // после этого из памяти вычищаются устаревшие ключи
+)
+
+// loginFailures - неудачные попытки входа по одному IP или имени.
+type loginFailures struct {
+	count       int
+	first       time.Time // начало окна
+	lockedUntil time.Time
+}
+
+// loginThrottle считает неудачные входы по IP и по имени пользователя
+// для формы входа и HTTP Basic.
+type loginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+var loginLimiter = newLoginThrottle()
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{failures: make(map[string]*loginFailures)}
+}
+
+// retryAfter пишет ответ 429 для заблокированного входа.
+func retryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+func loginThrottleKeys(ip, username string) (ipKey, userKey string) {
+	return "ip:" + ip, "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// Blocked возвращает, сколько еще ждать, если IP или имя заблокированы.
+func (t *loginThrottle) Blocked(ip, username string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	ipKey, userKey := loginThrottleKeys(ip, username)
+	for _, key := range []string{ipKey, userKey} {
+		if f := t.failures[key]; f != nil && f.lockedUntil.After(now) {
+			wait = max(wait, f.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Failed учитывает неудачную попытку входа.
+func (t *loginThrottle) Failed(ip, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if len(t.failures) >= maxLoginThrottleKeys {
+		t.prune(now)
+	}
+	ipKey, userKey := loginThrottleKeys(ip, username)
+	t.add(now, ipKey, maxLoginFailuresIP)
+	t.add(now, userKey, maxLoginFailuresUser)
+}
+
+// Succeeded сбрасывает счетчики после успешного входа.
+func (t *loginThrottle) Succeeded(ip, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ipKey, userKey := loginThrottleKeys(ip, username)
+	delete(t.failures, ipKey)
+	delete(t.failures, userKey)
+}
+
+func (t *loginThrottle) add(now time.Time, key string, limit int) {
+	f := t.failures[key]
+	if f == nil || now.Sub(f.first) > loginFailureWindow {
+		f = &loginFailures{first: now}
+		t.failures[key] = f
+	}
+	f.count++
+	if f.count >= limit {
+		f.lockedUntil = now.Add(loginLockout)
+		// Следующее окно начинается после блокировки
+		f.count, f.first = 0, f.lockedUntil
+	}
+}
+
+// prune удаляет ключи без блокировки и с истекшим окном.
+func (t *loginThrottle) prune(now time.Time) {
+	for key, f := range t.failures {
+		if f.lockedUntil.Before(now) && now.Sub(f.first) > loginFailureWindow {
+			delete(t.failures, key)
+		}
+	}
+}
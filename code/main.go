@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -13,8 +14,6 @@ import (
 
 	"github.com/gin-gonic/contrib/static"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 func NoBufferMiddleware() gin.HandlerFunc {
@@ -27,31 +26,69 @@ func NoBufferMiddleware() gin.HandlerFunc {
 }
 
 func main() {
+	targetAddr := flag.String("target-addr", "", "Address to serve speed test and IP echo target endpoints on, e.g. :8090 (disabled if empty)")
+	targetToken := flag.String("target-token", os.Getenv("PROXYCHECKER_TARGET_TOKEN"), "Shared token the target endpoints require as ?token= or Bearer (default $PROXYCHECKER_TARGET_TOKEN, empty = open)")
+	rotateKey := flag.Bool("rotate-key", false, "Generate a new master key, re-encrypt stored secrets with it and exit (the server must be stopped)")
+	pruneKeys := flag.Bool("prune-keys", false, "Remove previous master keys once no stored secret uses them and exit (the server must be stopped)")
+	envDriver, envDSN := dbConfigFromEnv()
+	dbDriver := flag.String("db-driver", envDriver, "Database driver: sqlite or postgres (default $PROXYCHECKER_DB_DRIVER or sqlite)")
+	dbDSN := flag.String("db-dsn", envDSN, "Database DSN: SQLite file path or PostgreSQL connection string (default $PROXYCHECKER_DB_DSN or database/proxy.db)")
+	flag.Parse()
+
 	log.Println("Starting Proxy Checker application...")
 
 	// Initialize a single database
-	db, err := gorm.Open(sqlite.Open("database/proxy.db"), &gorm.Config{})
+	db, err := OpenDatabase(*dbDriver, *dbDSN)
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
+	log.Printf("Using %s database", db.Dialector.Name())
 	var wg sync.WaitGroup
 	quit := make(chan struct{})
 
 	// Auto-migrate all models
-	if err := db.AutoMigrate(&Proxy{}, &Settings{}, &ProxySpeedLog{}, &ProxyIPLog{}, &ProxyVisitLogs{}, &ProxyFailureLog{}); err != nil {
+	if err := db.AutoMigrate(databaseModels...); err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
 	}
 
+	// Мастер-ключ для секретов в базе (пароли прокси, токены каналов)
+	if err := LoadSecrets(db); err != nil {
+		log.Fatalf("failed to load master key: %v", err)
+	}
+	if *rotateKey || *pruneKeys {
+		// Работающий сервер держит старую связку ключей и перезапишет ею секреты
+		lease, err := ActiveServerLease(db)
+		if err != nil {
+			log.Fatalf("failed to check for a running server: %v", err)
+		}
+		if lease != nil {
+			log.Fatalf("server is running on this database (host %s, pid %d), stop it before changing master keys", lease.Host, lease.PID)
+		}
+		if *rotateKey {
+			err = RotateMasterKey(db, os.Stdout)
+		} else {
+			err = PruneMasterKeys(db, os.Stdout)
+		}
+		if err != nil {
+			log.Fatalf("failed to change master keys: %v", err)
+		}
+		return
+	}
+	if err := EncryptLegacySecrets(db); err != nil {
+		log.Fatalf("failed to encrypt stored secrets: %v", err)
+	}
+
+	// Первый администратор и перенос старой пары логин/пароль из настроек
+	if err := EnsureAdmin(db); err != nil {
+		log.Fatalf("failed to initialize users: %v", err)
+	}
+
 	// Initialize Settings from the single database
 	settings := SettingsDefault(db)
 
 	// Initialize Notification Service
-	notificationService := NewNotificationService(
-		settings.TelegramEnabled,
-		settings.TelegramToken,
-		settings.TelegramChatID,
-	)
-	log.Printf("Notification service initialized. Telegram enabled: %v", settings.TelegramEnabled)
+	notificationService := NewNotificationService(settings)
+	log.Printf("Notification service initialized. Channels: %v", notificationService.Channels())
 
 	// Канал для инициирования перезапуска из API
 	restartSignal := make(chan struct{}, 1)
@@ -61,41 +98,78 @@ func main() {
 	router.Use(NoBufferMiddleware())
 
 
-	// Serve frontend static files
-	router.Use(gin.BasicAuth(gin.Accounts{settings.Username: settings.Password}), static.Serve("/", static.LocalFile("./client/dist", true)))
+	// Serve frontend static files. Страница входа доступна без авторизации,
+	// API закрыт AuthRequired ниже.
+	router.Use(static.Serve("/", static.LocalFile("./client/dist", true)))
 
-	// Init Geoip service
-	geoIP, err := NewGeoIPClient("GeoIP2-ISP.mmdb")
-	if err != nil {
-		log.Fatalf("failed to initialize GeoIP service: %v", err)
-	}
+	// Init Geoip service. Без баз сервер стартует в режиме "no GeoIP".
+	geoIP := NewGeoIPClient(GeoIPPaths{
+		ISP:     "GeoIP2-ISP.mmdb",
+		Country: "GeoLite2-Country.mmdb",
+		City:    "GeoLite2-City.mmdb",
+		ASN:     "GeoLite2-ASN.mmdb",
+	})
+	wg.Add(1)
+	go WatchGeoIP(&wg, quit, geoIP, time.Minute)
+
+	// Отметка работающего сервера для -rotate-key и -prune-keys
+	wg.Add(1)
+	go HoldServerLease(&wg, quit, db)
+
+	// SIGHUP перезагружает базы GeoIP без перезапуска
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading GeoIP databases...")
+			if err := geoIP.Reload(); err != nil {
+				log.Printf("GeoIP reload failed: %v", err)
+			}
+		}
+	}()
 
-	// Запускаем планировщик проверки IP в отдельной горутине.
-	go StartIPCheckScheduler(&wg, quit, db, settings, geoIP, notificationService)
-	go StartHealthCheckScheduler(&wg, quit, db, settings, notificationService)
+	// Запускаем планировщики проверок. Менеджер применяет изменения настроек на лету.
+	scheduler := NewSchedulerManager(db, settings, geoIP, notificationService)
+	scheduler.Start(&wg, quit)
 
 	// Create handler instance
 	h := handler{
 		db:            db,
-		settings:      settings,
 		geoIPClient:   geoIP,
+		scheduler:     scheduler,
 		restartSignal: restartSignal, // Передаем канал в обработчик
 	}
 
+	// Вход и выход
+	router.POST("/api/auth/login", h.Login)
+
+	// Остальной API - только для вошедших пользователей и API-токенов.
+	// Чтение доступно всем ролям, изменения - operator, настройки и
+	// пользователи - admin. Токены допускаются только на маршруты с правом.
+	api := router.Group("/api", AuthRequired(db))
+	viewer := api.Group("", RequireRole(RoleViewer))
+	operator := api.Group("", RequireRole(RoleOperator))
+	admin := api.Group("", RequireRole(RoleAdmin))
+
+	viewer.POST("auth/logout", h.Logout)
+	viewer.GET("auth/me", h.CurrentUser)
+	viewer.PUT("auth/password", h.ChangePassword)
+
 	// API routes for proxies
-	sseRoutes := router.Group("api/proxy")
+	sseRoutes := api.Group("proxy", RequireRole(RoleOperator, ScopeProxiesVerify))
 	sseRoutes.Use(func(c *gin.Context) {
 			// Отключаем логирование и другие middleware для SSE
 			c.Next()
 	})
 	sseRoutes.GET("verify-batch", h.VerifyBatch)
 
-	proxyRoutes := router.Group("api/proxy")
+	api.GET("proxy", RequireRole(RoleViewer, ScopeProxiesRead), h.ProxyList)
+	api.GET("proxy/:id/verify", RequireRole(RoleOperator, ScopeProxiesVerify), h.Verify)
+	proxyRoutes := operator.Group("proxy")
 	{ 
-		proxyRoutes.GET("", h.ProxyList)
     proxyRoutes.PUT(":id", h.UpdateProxy)
+    proxyRoutes.GET(":id/password", h.ProxyPassword)
     proxyRoutes.POST("", h.CreateProxy)
-    proxyRoutes.GET(":id/verify", h.Verify)
     proxyRoutes.DELETE(":id", h.Delete)
 	}
 
@@ -103,38 +177,78 @@ func main() {
 
 	// API routes for settings
 
-	settingsRoutes := router.Group("api/settings")
+	settingsRoutes := admin.Group("settings")
 
 	{
 		settingsRoutes.GET("", h.GetSettings)
 		settingsRoutes.PUT("", h.UpdateSettings)
 	}
 
+	// Users
+	userRoutes := admin.Group("users")
+
+	{
+		userRoutes.GET("", h.ListUsers)
+		userRoutes.POST("", h.CreateUser)
+		userRoutes.PUT(":id", h.UpdateUser)
+		userRoutes.DELETE(":id", h.DeleteUser)
+	}
+
+	// API tokens
+	tokenRoutes := admin.Group("tokens")
+
+	{
+		tokenRoutes.GET("", h.ListAPITokens)
+		tokenRoutes.POST("", h.CreateAPIToken)
+		tokenRoutes.DELETE(":id", h.RevokeAPIToken)
+	}
+
+	// Audit log
+	admin.GET("audit", h.GetAuditEvents)
+
+	// GeoIP routes
+	viewer.GET("geoip", h.GeoIPStatus)
+	operator.POST("geoip/reload", h.ReloadGeoIP)
+
 	// Export routes
-	exportRoutes := router.Group("api/export")
+	exportRoutes := operator.Group("export")
 
 	{
 		exportRoutes.GET("all", h.ExportAll)
 		exportRoutes.GET("selected", h.ExportSelected)
 	}
 	
-	router.POST("api/import", func(c *gin.Context) {
+	operator.POST("import", func(c *gin.Context) {
 		// Import proxies and run checks if successful
 		if err := h.ImportProxies(c); err != nil {
 			// Error response is already handled in ImportProxies
 			return
 		}
 
-		go RunSingleIPCheck(db, settings, geoIP, notificationService)
-		go RunSingleHealthCheck(db, settings, notificationService)
+		scheduler.RunNow(JobIPCheck)
+		scheduler.RunNow(JobHealthCheck)
 	})
-	router.GET("/api/speedLogs", h.GetSpeedLogs)
-	router.GET("/api/ipLogs", h.GetProxyIPLogs)
-	router.POST("/api/proxyVisits", h.CreateProxyVisitLog)
-	router.GET("/api/proxyVisits", h.GetProxyVisitLogs)
-	router.GET("/api/failureLogs", h.GetFailureLogs)
-	router.GET("/api/failureStats/:id", h.GetFailureStats)
-	router.POST("/api/testNotification", h.TestNotification)
+	viewer.GET("speedLogs", h.GetSpeedLogs)
+	viewer.GET("ipLogs", h.GetProxyIPLogs)
+	api.POST("proxyVisits", RequireRole(RoleOperator, ScopeVisitsWrite), h.CreateProxyVisitLog)
+	viewer.GET("proxyVisits", h.GetProxyVisitLogs)
+	viewer.GET("failureLogs", h.GetFailureLogs)
+	viewer.GET("failureStats", h.GetFleetFailureStats)
+	viewer.GET("failureStats/:id", h.GetFailureStats)
+	operator.POST("testNotification", h.TestNotification)
+	viewer.GET("scheduler", h.SchedulerStatus)
+	viewer.GET("checkerStatus", h.CheckerStatus)
+	viewer.GET("report", h.GetReport)
+	operator.POST("report/send", h.SendReport)
+	viewer.GET("alerts", h.GetAlerts)
+	viewer.GET("incidents", h.GetIncidents)
+	viewer.GET("incidents/:id", h.GetIncident)
+	api.GET("availability", RequireRole(RoleViewer, ScopeProxiesRead), h.GetAvailability)
+	api.GET("availability/:id", RequireRole(RoleViewer, ScopeProxiesRead), h.GetProxyAvailability)
+	api.GET("availability/:id/daily", RequireRole(RoleViewer, ScopeProxiesRead), h.GetDailyAvailability)
+	viewer.GET("tagSchedules", h.GetTagSchedules)
+	operator.PUT("tagSchedules/:tag", h.SaveTagSchedule)
+	operator.DELETE("tagSchedules/:tag", h.DeleteTagSchedule)
 
 	// Handle SPA routing (Vue Router history mode)
 	router.NoRoute(func(c *gin.Context) {
@@ -158,6 +272,21 @@ func main() {
 		}
 	}()
 
+	// Эндпоинты для замеров с другого экземпляра чекера
+	var targetSrv *http.Server
+	if *targetAddr != "" {
+		if *targetToken == "" {
+			log.Printf("WARNING: target endpoints on %s accept requests without a token, set -target-token", *targetAddr)
+		}
+		targetSrv = NewTargetServer(*targetAddr, *targetToken)
+		go func() {
+			log.Printf("Target endpoints running on %s", *targetAddr)
+			if err := targetSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("target listen: %s\n", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -177,6 +306,11 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	if targetSrv != nil {
+		if err := targetSrv.Shutdown(ctx); err != nil {
+			log.Printf("Target server forced to shutdown: %v", err)
+		}
+	}
 	close(quit)
 	wg.Wait() // Ожидаем завершения всех горутин.
 
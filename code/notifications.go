@@ -1,231 +1,287 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
-// NotificationService handles sending notifications
-type NotificationService struct {
-	TelegramEnabled bool
-	TelegramToken   string
-	TelegramChatID  string
-	client          *http.Client
+// Типы событий уведомлений
+const (
+	EventProxyDown      = "proxy_down"
+	EventProxyRecovered = "proxy_recovered"
+	EventProxyFlapping  = "proxy_flapping"
+	EventOutage         = "outage"
+	EventSLABreach      = "sla_breach"
+	EventIPChanged      = "ip_changed"
+	EventIPStuck        = "ip_stuck"
+	EventLowSpeed       = "low_speed"
+	EventDailySummary   = "daily_summary"
+	EventWeeklySummary  = "weekly_summary"
+	EventTest           = "test"
+)
+
+// Таймаут доставки одного события во все каналы, включая повторы
+const notifyTimeout = 2 * time.Minute
+
+// Event - структурированное событие, которое каналы форматируют по-своему.
+// Основные поля (тип, прокси, IP, ошибка, время) есть в JSON всегда,
+// остальные - только у событий, к которым они относятся.
+type Event struct {
+	Type      string    `json:"type"`
+	ProxyID   string    `json:"proxy_id"`
+	Name      string    `json:"name"`
+	Proxy     string    `json:"proxy,omitempty"` // ip:port
+	Username  string    `json:"username,omitempty"`
+	Contacts  string    `json:"contacts,omitempty"`
+	OldIP     string    `json:"old_ip"`
+	NewIP     string    `json:"new_ip"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Failures  int    `json:"failures,omitempty"`
+	Latency   int    `json:"latency,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Operator  string `json:"operator,omitempty"`
+	Hours     int    `json:"hours,omitempty"`
+	Download  int    `json:"download,omitempty"`
+	Upload    int    `json:"upload,omitempty"`
+	Threshold int    `json:"threshold,omitempty"`
+	Changes   int    `json:"changes,omitempty"`        // смен состояния за окно флаппинга
+	Window    int    `json:"window_minutes,omitempty"` // окно флаппинга, мин
+	Message   string `json:"message,omitempty"`
+
+	Availability float64 `json:"availability,omitempty"` // процент за период SLA
+	SLATarget    float64 `json:"sla_target,omitempty"`
+	SLAWindow    int     `json:"sla_window_days,omitempty"`
+
+	Summary *SummaryData `json:"summary,omitempty"`
+	Outage  *Outage      `json:"outage,omitempty"`
 }
 
-// NewNotificationService creates a new notification service
-func NewNotificationService(enabled bool, token, chatID string) *NotificationService {
-	return &NotificationService{
-		TelegramEnabled: enabled,
-		TelegramToken:   token,
-		TelegramChatID:  chatID,
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+// percent возвращает долю в процентах, 0 для пустого парка.
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
 	}
+	return float64(part) / float64(total) * 100
 }
 
-// TelegramMessage represents a Telegram API message
-type TelegramMessage struct {
-	ChatID    string `json:"chat_id"`
-	Text      string `json:"text"`
-	ParseMode string `json:"parse_mode,omitempty"`
+// proxyEvent заполняет общие поля события о прокси.
+func proxyEvent(eventType string, proxy *Proxy) Event {
+	return Event{
+		Type:      eventType,
+		ProxyID:   proxy.Id,
+		Name:      proxy.Name,
+		Proxy:     proxy.Ip + ":" + proxy.Port,
+		Username:  proxy.Username,
+		Contacts:  proxy.Contacts,
+		Timestamp: time.Now(),
+	}
 }
 
-// SendTelegram sends a message to Telegram
-func (n *NotificationService) SendTelegram(message string) error {
-	if !n.TelegramEnabled || n.TelegramToken == "" || n.TelegramChatID == "" {
-		return nil // Notifications disabled
-	}
+// Notifier - канал доставки уведомлений.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event Event) error
+}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.TelegramToken)
+// NotificationService рассылает события во все включенные каналы.
+// Каналы пересобираются из настроек при их изменении.
+type NotificationService struct {
+	mu       sync.RWMutex
+	channels []Notifier
+}
 
-	msg := TelegramMessage{
-		ChatID:    n.TelegramChatID,
-		Text:      message,
-		ParseMode: "HTML",
-	}
+// NewNotificationService creates a new notification service
+func NewNotificationService(settings *Settings) *NotificationService {
+	n := &NotificationService{}
+	n.Configure(settings)
+	return n
+}
 
-	jsonData, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal telegram message: %w", err)
-	}
+// Configure пересобирает каналы из настроек и закрывает прежние.
+func (n *NotificationService) Configure(settings *Settings) {
+	channels := buildNotifiers(settings)
+
+	n.mu.Lock()
+	prev := n.channels
+	n.channels = channels
+	n.mu.Unlock()
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create telegram request: %w", err)
+	// Каналы с буфером (дайджест email) отправляют накопленное
+	for _, ch := range prev {
+		if c, ok := ch.(io.Closer); ok {
+			go c.Close()
+		}
 	}
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// buildNotifiers возвращает включенные и настроенные каналы.
+func buildNotifiers(settings *Settings) []Notifier {
+	var channels []Notifier
+	if settings.TelegramEnabled && settings.TelegramToken != "" && settings.TelegramChatID != "" {
+		channels = append(channels, NewTelegramNotifier(settings.TelegramToken, settings.TelegramChatID, settings.TelegramEvents))
+	}
+	if settings.SlackEnabled && settings.SlackWebhookURL != "" {
+		channels = append(channels, NewSlackNotifier(settings.SlackWebhookURL, settings.SlackEvents))
+	}
+	if settings.DiscordEnabled && settings.DiscordWebhookURL != "" {
+		channels = append(channels, NewDiscordNotifier(settings.DiscordWebhookURL, settings.DiscordEvents))
+	}
+	for _, target := range settings.Webhooks {
+		if target.Enabled && target.URL != "" {
+			channels = append(channels, NewWebhookNotifier(target))
+		}
+	}
+	if settings.EmailEnabled && settings.SMTPHost != "" && settings.EmailFrom != "" {
+		channels = append(channels, NewEmailNotifier(SMTPConfig{
+			Host:     settings.SMTPHost,
+			Port:     settings.SMTPPort,
+			Security: settings.SMTPSecurity,
+			Username: settings.SMTPUsername,
+			Password: settings.SMTPPassword,
+			From:     settings.EmailFrom,
+		}, settings.EmailTo, settings.EmailNotifyContacts, settings.EmailEvents, settings.EmailDigestMinutes))
+	}
+	return channels
+}
 
-	resp, err := n.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send telegram message: %w", err)
+// Channels возвращает имена активных каналов.
+func (n *NotificationService) Channels() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	names := make([]string, 0, len(n.channels))
+	for _, ch := range n.channels {
+		names = append(names, ch.Name())
 	}
-	defer resp.Body.Close()
+	return names
+}
+
+// Send синхронно доставляет событие во все каналы и возвращает их ошибки.
+func (n *NotificationService) Send(ctx context.Context, event Event) error {
+	n.mu.RLock()
+	channels := n.channels
+	n.mu.RUnlock()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API returned status %d", resp.StatusCode)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch Notifier) {
+			defer wg.Done()
+			if err := ch.Notify(ctx, event); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", ch.Name(), err))
+				mu.Unlock()
+			}
+		}(ch)
 	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-	log.Printf("Telegram notification sent successfully")
-	return nil
+// emit отправляет событие в фоне, чтобы повторы доставки не задерживали проверки.
+func (n *NotificationService) emit(event Event) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		if err := n.Send(ctx, event); err != nil {
+			log.Printf("Failed to send %s notification: %v", event.Type, err)
+		}
+	}()
 }
 
 // NotifyProxyDown sends notification when proxy goes down
 func (n *NotificationService) NotifyProxyDown(proxy *Proxy, errorMsg string) {
-	message := fmt.Sprintf(
-		"🔴 <b>Proxy Down</b>\n\n"+
-			"<b>Name:</b> %s\n"+
-			"<b>IP:</b> %s:%s\n"+
-			"<b>Username:</b> %s\n"+
-			"<b>Failures:</b> %d\n"+
-			"<b>Error:</b> %s\n"+
-			"<b>Time:</b> %s",
-		escapeHTML(proxy.Name),
-		proxy.Ip,
-		proxy.Port,
-		escapeHTML(proxy.Username),
-		proxy.Failures,
-		escapeHTML(errorMsg),
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
-	}
+	event := proxyEvent(EventProxyDown, proxy)
+	event.Failures = proxy.Failures
+	event.Error = errorMsg
+	n.emit(event)
 }
 
 // NotifyProxyRecovered sends notification when proxy recovers
 func (n *NotificationService) NotifyProxyRecovered(proxy *Proxy) {
-	message := fmt.Sprintf(
-		"🟢 <b>Proxy Recovered</b>\n\n"+
-			"<b>Name:</b> %s\n"+
-			"<b>IP:</b> %s:%s\n"+
-			"<b>Username:</b> %s\n"+
-			"<b>Latency:</b> %d ms\n"+
-			"<b>Time:</b> %s",
-		escapeHTML(proxy.Name),
-		proxy.Ip,
-		proxy.Port,
-		escapeHTML(proxy.Username),
-		proxy.LastLatency,
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
+	event := proxyEvent(EventProxyRecovered, proxy)
+	event.Latency = proxy.LastLatency
+	n.emit(event)
+}
 
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
-	}
+// NotifyProxyFlapping sends notification when proxy keeps switching between down and up
+func (n *NotificationService) NotifyProxyFlapping(proxy *Proxy, changes, windowMinutes int) {
+	event := proxyEvent(EventProxyFlapping, proxy)
+	event.Failures = proxy.Failures
+	event.Changes = changes
+	event.Window = windowMinutes
+	n.emit(event)
+}
+
+// NotifyOutage sends one notification for a group of proxies that went down together
+func (n *NotificationService) NotifyOutage(outage Outage) {
+	n.emit(Event{
+		Type:      EventOutage,
+		Timestamp: time.Now(),
+		Outage:    &outage,
+	})
+}
+
+// NotifySLABreach sends notification when proxy availability drops below its SLA target
+func (n *NotificationService) NotifySLABreach(proxy *Proxy, a Availability) {
+	event := proxyEvent(EventSLABreach, proxy)
+	event.Availability = a.Availability
+	event.SLATarget = a.SLATarget
+	event.SLAWindow = int(a.To.Sub(a.From).Hours() / 24)
+	n.emit(event)
 }
 
 // NotifyIPChanged sends notification when proxy IP changes
 func (n *NotificationService) NotifyIPChanged(proxy *Proxy, oldIP, newIP string) {
-	message := fmt.Sprintf(
-		"🔄 <b>IP Changed</b>\n\n"+
-			"<b>Name:</b> %s\n"+
-			"<b>Proxy:</b> %s:%s\n"+
-			"<b>Username:</b> %s\n"+
-			"<b>Old IP:</b> %s\n"+
-			"<b>New IP:</b> %s\n"+
-			"<b>Country:</b> %s\n"+
-			"<b>Operator:</b> %s\n"+
-			"<b>Time:</b> %s",
-		escapeHTML(proxy.Name),
-		proxy.Ip,
-		proxy.Port,
-		escapeHTML(proxy.Username),
-		oldIP,
-		newIP,
-		escapeHTML(proxy.RealCountry),
-		escapeHTML(proxy.Operator),
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
-	}
+	event := proxyEvent(EventIPChanged, proxy)
+	event.OldIP = oldIP
+	event.NewIP = newIP
+	event.Country = proxy.RealCountry
+	event.Operator = proxy.Operator
+	n.emit(event)
 }
 
 // NotifyIPStuck sends notification when IP is stuck (>24 hours)
 func (n *NotificationService) NotifyIPStuck(proxy *Proxy, stuckIP string, hours int) {
-	message := fmt.Sprintf(
-		"⚠️ <b>IP Stuck</b>\n\n"+
-			"<b>Name:</b> %s\n"+
-			"<b>Proxy:</b> %s:%s\n"+
-			"<b>Username:</b> %s\n"+
-			"<b>Stuck IP:</b> %s\n"+
-			"<b>Duration:</b> %d hours\n"+
-			"<b>Country:</b> %s\n"+
-			"<b>Operator:</b> %s\n"+
-			"<b>Time:</b> %s",
-		escapeHTML(proxy.Name),
-		proxy.Ip,
-		proxy.Port,
-		escapeHTML(proxy.Username),
-		stuckIP,
-		hours,
-		escapeHTML(proxy.RealCountry),
-		escapeHTML(proxy.Operator),
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
-	}
+	event := proxyEvent(EventIPStuck, proxy)
+	event.NewIP = stuckIP
+	event.Hours = hours
+	event.Country = proxy.RealCountry
+	event.Operator = proxy.Operator
+	n.emit(event)
 }
 
 // NotifyLowSpeed sends notification when proxy speed is below threshold
 func (n *NotificationService) NotifyLowSpeed(proxy *Proxy, threshold int) {
-	message := fmt.Sprintf(
-		"🐌 <b>Low Speed Detected</b>\n\n"+
-			"<b>Name:</b> %s\n"+
-			"<b>Proxy:</b> %s:%s\n"+
-			"<b>Username:</b> %s\n"+
-			"<b>Download:</b> %d Mbps\n"+
-			"<b>Upload:</b> %d Mbps\n"+
-			"<b>Threshold:</b> %d Mbps\n"+
-			"<b>Time:</b> %s",
-		escapeHTML(proxy.Name),
-		proxy.Ip,
-		proxy.Port,
-		escapeHTML(proxy.Username),
-		proxy.Speed,
-		proxy.Upload,
-		threshold,
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
-	}
+	event := proxyEvent(EventLowSpeed, proxy)
+	event.Download = proxy.Speed
+	event.Upload = proxy.Upload
+	event.Threshold = threshold
+	n.emit(event)
 }
 
-// NotifyDailySummary sends a daily summary of proxy status
-func (n *NotificationService) NotifyDailySummary(totalProxies, aliveProxies, deadProxies int, avgSpeed float64) {
-	message := fmt.Sprintf(
-		"📊 <b>Daily Proxy Summary</b>\n\n"+
-			"<b>Total Proxies:</b> %d\n"+
-			"<b>Alive:</b> %d (%.1f%%)\n"+
-			"<b>Dead:</b> %d (%.1f%%)\n"+
-			"<b>Avg Speed:</b> %.1f Mbps\n"+
-			"<b>Date:</b> %s",
-		totalProxies,
-		aliveProxies,
-		float64(aliveProxies)/float64(totalProxies)*100,
-		deadProxies,
-		float64(deadProxies)/float64(totalProxies)*100,
-		avgSpeed,
-		time.Now().Format("2006-01-02"),
-	)
-
-	if err := n.SendTelegram(message); err != nil {
-		log.Printf("Failed to send telegram notification: %v", err)
+// NotifySummary sends a daily or weekly summary of proxy status
+func (n *NotificationService) NotifySummary(summary *SummaryData) {
+	eventType := EventDailySummary
+	if summary.Period == ReportWeekly {
+		eventType = EventWeeklySummary
 	}
+	n.emit(Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Summary:   summary,
+	})
 }
 
 // escapeHTML escapes HTML special characters for Telegram
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DiscordNotifier отправляет события в вебхук Discord в виде embed.
+type DiscordNotifier struct {
+	WebhookURL string
+	Events     []string
+	client     *http.Client
+}
+
+func NewDiscordNotifier(webhookURL string, events []string) *DiscordNotifier {
+	return &DiscordNotifier{
+		WebhookURL: webhookURL,
+		Events:     events,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (d *DiscordNotifier) Name() string { return "discord" }
+
+func (d *DiscordNotifier) Notify(ctx context.Context, event Event) error {
+	if !wantsEvent(d.Events, event.Type) {
+		return nil
+	}
+
+	body, err := json.Marshal(formatDiscord(event))
+	if err != nil {
+		return fmt.Errorf("failed to marshal discord message: %w", err)
+	}
+
+	// Discord отвечает 204 No Content, а при лимитах - 429, который повторяем
+	return deliverWithRetry(ctx, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return false, fmt.Errorf("failed to create discord request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return postOnce(d.client, req)
+	})
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	Color     int            `json:"color"`
+	Fields    []discordField `json:"fields"`
+	Timestamp string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Лимиты Discord на embed
+const (
+	discordMaxFields     = 25
+	discordMaxFieldValue = 1024
+)
+
+func formatDiscord(e Event) discordMessage {
+	var fields []discordField
+	for _, f := range eventFields(e) {
+		if len(fields) == discordMaxFields {
+			break
+		}
+		value := truncateRunes(fieldValue(f.Value), discordMaxFieldValue-6)
+		// Длинные ошибки выводим блоком кода во всю ширину
+		inline := !isListField(f.Name)
+		if !inline {
+			value = "```" + strings.ReplaceAll(value, "```", "'''") + "```"
+		}
+		fields = append(fields, discordField{Name: f.Name, Value: value, Inline: inline})
+	}
+
+	return discordMessage{
+		Embeds: []discordEmbed{{
+			Title:     eventTitle(e),
+			Color:     eventColor(e),
+			Fields:    fields,
+			Timestamp: e.Timestamp.UTC().Format(time.RFC3339),
+		}},
+	}
+}
+
+// truncateRunes обрезает строку до n символов, не разрывая UTF-8.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Режимы шифрования SMTP
+const (
+	SMTPSecurityNone     = "none"     // без шифрования (локальный relay, MailHog/Mailpit)
+	SMTPSecurityStartTLS = "starttls" // обычно порт 587
+	SMTPSecurityTLS      = "tls"      // неявный TLS, обычно порт 465
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTPConfig - параметры подключения к почтовому серверу.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Security string
+	Username string
+	Password string
+	From     string
+
+	tlsConfig *tls.Config // nil - системные корневые сертификаты (в тестах - свой CA)
+}
+
+// addr возвращает host:port; без порта берется стандартный для режима шифрования.
+func (c SMTPConfig) addr() string {
+	port := c.Port
+	if port == 0 {
+		switch c.Security {
+		case SMTPSecurityTLS:
+			port = 465
+		case SMTPSecurityStartTLS:
+			port = 587
+		default:
+			port = 25
+		}
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+// EmailNotifier отправляет события письмами multipart/alternative (HTML + текст).
+// Получатели - адреса из настроек и, если включено, адрес из Contacts прокси.
+// При DigestMinutes > 0 события копятся и уходят одним письмом на получателя.
+type EmailNotifier struct {
+	smtp          SMTPConfig
+	to            []string
+	notifyContact bool
+	events        []string
+	digest        time.Duration
+
+	mu      sync.Mutex
+	pending map[string][]Event // получатель -> события для дайджеста
+	timer   *time.Timer
+}
+
+func NewEmailNotifier(cfg SMTPConfig, to []string, notifyContacts bool, events []string, digestMinutes int) *EmailNotifier {
+	return &EmailNotifier{
+		smtp:          cfg,
+		to:            to,
+		notifyContact: notifyContacts,
+		events:        events,
+		digest:        time.Duration(digestMinutes) * time.Minute,
+		pending:       make(map[string][]Event),
+	}
+}
+
+func (e *EmailNotifier) Name() string { return "email" }
+
+func (e *EmailNotifier) Notify(ctx context.Context, event Event) error {
+	if !wantsEvent(e.events, event.Type) {
+		return nil
+	}
+	recipients := e.recipients(event)
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	// Тестовое письмо отправляем сразу, чтобы проверить настройки
+	if e.digest <= 0 || event.Type == EventTest {
+		var errs []error
+		for _, to := range recipients {
+			if err := e.send(ctx, to, []Event{event}); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", to, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, to := range recipients {
+		e.pending[to] = append(e.pending[to], event)
+	}
+	if e.timer == nil {
+		e.timer = time.AfterFunc(e.digest, e.flush)
+	}
+	return nil
+}
+
+// Close отправляет накопленный дайджест. Вызывается при смене настроек.
+func (e *EmailNotifier) Close() error {
+	e.mu.Lock()
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.mu.Unlock()
+	e.flush()
+	return nil
+}
+
+// flush отправляет по одному письму-дайджесту каждому получателю.
+func (e *EmailNotifier) flush() {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = make(map[string][]Event)
+	e.timer = nil
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	for to, events := range pending {
+		if err := e.send(ctx, to, events); err != nil {
+			log.Printf("Failed to send email digest to %s: %v", to, err)
+		}
+	}
+}
+
+// recipients - адреса из настроек плюс адрес владельца из Contacts прокси.
+func (e *EmailNotifier) recipients(event Event) []string {
+	seen := make(map[string]bool)
+	var list []string
+	add := func(addr string) {
+		parsed, err := mail.ParseAddress(strings.TrimSpace(addr))
+		if err != nil {
+			return
+		}
+		key := strings.ToLower(parsed.Address)
+		if !seen[key] {
+			seen[key] = true
+			list = append(list, parsed.Address)
+		}
+	}
+	for _, addr := range e.to {
+		add(addr)
+	}
+	if e.notifyContact && event.Contacts != "" && event.Type != EventTest {
+		add(event.Contacts)
+	}
+	return list
+}
+
+// send отправляет письмо с одним событием или дайджестом, повторяя
+// временные ошибки (сеть, ответы 4xx).
+func (e *EmailNotifier) send(ctx context.Context, to string, events []Event) error {
+	msg, err := buildEmail(e.smtp.From, to, events)
+	if err != nil {
+		return err
+	}
+	return deliverWithRetry(ctx, func() (bool, error) {
+		err := sendSMTP(e.smtp, to, msg)
+		var tpErr *textproto.Error
+		if errors.As(err, &tpErr) {
+			return tpErr.Code >= 400 && tpErr.Code < 500, err
+		}
+		return err != nil, err
+	})
+}
+
+// sendSMTP доставляет одно письмо с учетом режима шифрования.
+func sendSMTP(cfg SMTPConfig, to string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	if cfg.tlsConfig != nil {
+		tlsConfig = cfg.tlsConfig.Clone()
+		tlsConfig.ServerName = cfg.Host
+	}
+
+	var conn net.Conn
+	var err error
+	if cfg.Security == SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.addr(), tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", cfg.addr())
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.Security == SMTPSecurityStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP auth failed: %w", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail собирает письмо multipart/alternative с текстовой и HTML-частью.
+func buildEmail(from, to string, events []Event) ([]byte, error) {
+	subject := eventTitle(events[0])
+	if len(events) > 1 {
+		subject = fmt.Sprintf("Proxy Checker digest: %d events", len(events))
+	}
+	if events[0].Name != "" && len(events) == 1 {
+		subject += ": " + events[0].Name
+	}
+
+	var htmlBody bytes.Buffer
+	if err := emailTemplate.Execute(&htmlBody, emailView(events)); err != nil {
+		return nil, fmt.Errorf("failed to render email: %w", err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@proxychecker>", uuid.NewString())},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", emailPlainText(events)},
+		{"text/html; charset=utf-8", htmlBody.String()},
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+		qp.Close()
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	msg.Write(buf.Bytes())
+	return msg.Bytes(), nil
+}
+
+// emailPlainText - текстовая часть письма.
+func emailPlainText(events []Event) string {
+	var b strings.Builder
+	for i, event := range events {
+		if i > 0 {
+			b.WriteString("\r\n----------------------------------------\r\n\r\n")
+		}
+		b.WriteString(eventTitle(event) + "\r\n\r\n")
+		for _, f := range eventFields(event) {
+			fmt.Fprintf(&b, "%s: %s\r\n", f.Name, fieldValue(f.Value))
+		}
+		fmt.Fprintf(&b, "Time: %s\r\n", event.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	return b.String()
+}
+
+type emailEventView struct {
+	Title  string
+	Color  string
+	Fields []eventField
+	Time   string
+}
+
+func emailView(events []Event) []emailEventView {
+	views := make([]emailEventView, 0, len(events))
+	for _, event := range events {
+		fields := eventFields(event)
+		for i := range fields {
+			fields[i].Value = fieldValue(fields[i].Value)
+		}
+		views = append(views, emailEventView{
+			Title:  eventTitle(event),
+			Color:  fmt.Sprintf("#%06x", eventColor(event)),
+			Fields: fields,
+			Time:   event.Timestamp.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return views
+}
+
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html><body style="font-family: Arial, sans-serif; color: #1c2434;">
+{{range .}}<div style="border-left: 4px solid {{.Color}}; padding: 8px 16px; margin-bottom: 16px;">
+<h3 style="margin: 0 0 8px;">{{.Title}}</h3>
+<table cellpadding="4" style="border-collapse: collapse;">
+{{range .Fields}}<tr><td style="color: #64748b;">{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+<p style="color: #64748b; font-size: 12px; margin: 8px 0 0;">{{.Time}}</p>
+</div>
+{{end}}</body></html>
+`))
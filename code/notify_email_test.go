@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub - SMTP-сервер в процессе теста. Записывает AUTH, STARTTLS и
+// принятые письма.
+type smtpStub struct {
+	listener net.Listener
+	tls      *tls.Config // не nil - сервер предлагает STARTTLS
+
+	mu       sync.Mutex
+	messages []stubMessage
+	auth     []string // расшифрованные AUTH PLAIN: "user:password"
+	startTLS int
+}
+
+type stubMessage struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+}
+
+func newSMTPStub(t *testing.T, tlsConfig *tls.Config) *smtpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStub{listener: l, tls: tlsConfig}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStub) config() SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPConfig{Host: host, Port: p, Security: SMTPSecurityNone, From: "Proxy Checker <checker@example.com>"}
+}
+
+func (s *smtpStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStub) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	secure := false
+	var msg stubMessage
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			io.WriteString(conn, "250-stub\r\n")
+			if s.tls != nil && !secure {
+				io.WriteString(conn, "250-STARTTLS\r\n")
+			}
+			reply("250 AUTH PLAIN")
+		case cmd == "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				// Клиент может отвергнуть сертификат - это проверяет отдельный тест
+				return
+			}
+			conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+			s.mu.Lock()
+			s.startTLS++
+			s.mu.Unlock()
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			raw, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line[len("AUTH PLAIN"):]))
+			parts := strings.Split(string(raw), "\x00")
+			s.mu.Lock()
+			if len(parts) == 3 {
+				s.auth = append(s.auth, parts[1]+":"+parts[2])
+			}
+			s.mu.Unlock()
+			reply("235 authenticated")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = stubMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<> "), tls: secure}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpStub) snapshot() (messages []stubMessage, auth []string, startTLS int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubMessage(nil), s.messages...), append([]string(nil), s.auth...), s.startTLS
+}
+
+// testTLSConfigs возвращает конфигурацию сервера с самоподписанным
+// сертификатом на 127.0.0.1 и клиента, который ему доверяет.
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smtp stub"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func testEvent(eventType, name string) Event {
+	return Event{
+		Type:      eventType,
+		ProxyID:   "p1",
+		Name:      name,
+		Proxy:     "10.0.0.1:8080",
+		Error:     "connection refused",
+		Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// parseTestEmail разбирает письмо и возвращает заголовки и части по Content-Type.
+func parseTestEmail(t *testing.T, data string) (mail.Header, map[string]string) {
+	t.Helper()
+	m, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parse content type: %v", err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", mediaType)
+	}
+	parts := make(map[string]string)
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		// multipart.Reader сам декодирует quoted-printable
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("read part body: %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[partType] = string(body)
+	}
+	return m.Header, parts
+}
+
+func TestEmailNotifierSendsMultipartMessage(t *testing.T) {
+	stub := newSMTPStub(t, nil)
+	cfg := stub.config()
+	cfg.Username = "checker"
+	cfg.Password = "s3cret"
+
+	n := NewEmailNotifier(cfg, []string{"ops@example.com"}, false, nil, 0)
+	if err := n.Notify(context.Background(), testEvent(EventProxyDown, "edge-1")); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages, auth, _ := stub.snapshot()
+	if len(auth) != 1 || auth[0] != "checker:s3cret" {
+		t.Errorf("AUTH = %v, want [checker:s3cret]", auth)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.from != "checker@example.com" || len(msg.to) != 1 || msg.to[0] != "ops@example.com" {
+		t.Errorf("envelope = %s -> %v", msg.from, msg.to)
+	}
+
+	header, parts := parseTestEmail(t, msg.data)
+	if got := header.Get("To"); got != "ops@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := header.Get("From"); got != cfg.From {
+		t.Errorf("From = %q, want %q", got, cfg.From)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || !strings.HasSuffix(subject, ": edge-1") {
+		t.Errorf("Subject = %q (%v), want the proxy name", subject, err)
+	}
+	for _, h := range []string{"Date", "Message-Id", "Mime-Version"} {
+		if header.Get(h) == "" {
+			t.Errorf("missing %s header", h)
+		}
+	}
+	if !strings.Contains(parts["text/plain"], "connection refused") {
+		t.Errorf("plain part does not contain the error:\n%s", parts["text/plain"])
+	}
+	if html := parts["text/html"]; !strings.Contains(html, "<html>") || !strings.Contains(html, "connection refused") {
+		t.Errorf("html part is not the rendered template:\n%s", html)
+	}
+}
+
+func TestEmailNotifierStartTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	stub := newSMTPStub(t, serverTLS)
+	cfg := stub.config()
+	cfg.Security = SMTPSecurityStartTLS
+	cfg.Username = "checker"
+	cfg.Password = "s3cret"
+	cfg.tlsConfig = clientTLS
+
+	n := NewEmailNotifier(cfg, []string{"ops@example.com"}, false, nil, 0)
+	if err := n.Notify(context.Background(), testEvent(EventTest, "")); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages, auth, startTLS := stub.snapshot()
+	if startTLS != 1 {
+		t.Errorf("STARTTLS negotiated %d times, want 1", startTLS)
+	}
+	if len(auth) != 1 {
+		t.Errorf("AUTH = %v, want one login after STARTTLS", auth)
+	}
+	if len(messages) != 1 || !messages[0].tls {
+		t.Fatalf("messages = %+v, want one sent over TLS", messages)
+	}
+}
+
+func TestEmailNotifierStartTLSRejectsUntrustedCertificate(t *testing.T) {
+	serverTLS, _ := testTLSConfigs(t)
+	stub := newSMTPStub(t, serverTLS)
+	cfg := stub.config()
+	cfg.Security = SMTPSecurityStartTLS
+
+	if err := sendSMTP(cfg, "ops@example.com", []byte("Subject: x\r\n\r\nx\r\n")); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("sendSMTP error = %v, want STARTTLS failure", err)
+	}
+	if messages, _, _ := stub.snapshot(); len(messages) != 0 {
+		t.Errorf("message was delivered over an unverified connection")
+	}
+}
+
+func TestEmailNotifierDigestSendsOneMailPerWindow(t *testing.T) {
+	stub := newSMTPStub(t, nil)
+	n := NewEmailNotifier(stub.config(), []string{"ops@example.com", "noc@example.com"}, false, nil, 0)
+	n.digest = 100 * time.Millisecond
+
+	for _, name := range []string{"edge-1", "edge-2", "edge-3"} {
+		if err := n.Notify(context.Background(), testEvent(EventProxyDown, name)); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if messages, _, _ := stub.snapshot(); len(messages) != 0 {
+		t.Fatalf("%d messages sent before the digest window closed", len(messages))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var messages []stubMessage
+	for time.Now().Before(deadline) {
+		if messages, _, _ = stub.snapshot(); len(messages) >= 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages after the window, want one per recipient", len(messages))
+	}
+	for _, msg := range messages {
+		header, parts := parseTestEmail(t, msg.data)
+		subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+		if !strings.Contains(subject, "3 events") {
+			t.Errorf("digest subject = %q, want 3 events", subject)
+		}
+		for _, name := range []string{"edge-1", "edge-2", "edge-3"} {
+			if !strings.Contains(parts["text/plain"], name) {
+				t.Errorf("digest to %v is missing %s", msg.to, name)
+			}
+		}
+	}
+
+	// Следующее окно - снова одно письмо на получателя
+	if err := n.Notify(context.Background(), testEvent(EventProxyRecovered, "edge-1")); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	n.Close()
+	if messages, _, _ = stub.snapshot(); len(messages) != 4 {
+		t.Errorf("got %d messages after the second window, want 4", len(messages))
+	}
+}
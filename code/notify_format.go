@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// eventField - пара "название: значение" для каналов с табличной разметкой
+// (Slack, Discord, email).
+type eventField struct {
+	Name  string
+	Value string
+}
+
+// eventTitle возвращает заголовок события с эмодзи, как в Telegram.
+func eventTitle(e Event) string {
+	switch e.Type {
+	case EventProxyDown:
+		return "🔴 Proxy Down"
+	case EventProxyRecovered:
+		return "🟢 Proxy Recovered"
+	case EventProxyFlapping:
+		return "🟠 Proxy Flapping"
+	case EventOutage:
+		return "🚨 Mass Outage"
+	case EventSLABreach:
+		return "📉 SLA Breach"
+	case EventIPChanged:
+		return "🔄 IP Changed"
+	case EventIPStuck:
+		return "⚠️ IP Stuck"
+	case EventLowSpeed:
+		return "🐌 Low Speed Detected"
+	case EventDailySummary:
+		return "📊 Daily Proxy Summary"
+	case EventWeeklySummary:
+		return "📊 Weekly Proxy Summary"
+	default:
+		return "🧪 Test Notification"
+	}
+}
+
+// eventColor - цвет полосы сообщения (RGB) по типу события.
+func eventColor(e Event) int {
+	switch e.Type {
+	case EventProxyDown:
+		return 0xE53935
+	case EventOutage:
+		return 0xB71C1C
+	case EventProxyRecovered:
+		return 0x43A047
+	case EventProxyFlapping, EventIPStuck, EventLowSpeed, EventSLABreach:
+		return 0xFB8C00
+	default:
+		return 0x1E88E5
+	}
+}
+
+// eventFields возвращает поля события в порядке показа. Значения без экранирования:
+// каждый канал экранирует их по своим правилам.
+func eventFields(e Event) []eventField {
+	proxy := []eventField{{"Name", e.Name}, {"Proxy", e.Proxy}}
+	if e.Username != "" {
+		proxy = append(proxy, eventField{"Username", e.Username})
+	}
+
+	switch e.Type {
+	case EventProxyDown:
+		return append(proxy,
+			eventField{"Failures", fmt.Sprint(e.Failures)},
+			eventField{"Error", e.Error},
+		)
+	case EventProxyRecovered:
+		return append(proxy, eventField{"Latency", fmt.Sprintf("%d ms", e.Latency)})
+	case EventProxyFlapping:
+		return append(proxy,
+			eventField{"State Changes", fmt.Sprintf("%d in %d min", e.Changes, e.Window)},
+		)
+	case EventSLABreach:
+		return append(proxy,
+			eventField{"Availability", fmt.Sprintf("%.2f%%", e.Availability)},
+			eventField{"SLA Target", fmt.Sprintf("%.2f%%", e.SLATarget)},
+			eventField{"Period", fmt.Sprintf("%d days", e.SLAWindow)},
+		)
+	case EventIPChanged:
+		return append(proxy,
+			eventField{"Old IP", e.OldIP},
+			eventField{"New IP", e.NewIP},
+			eventField{"Country", e.Country},
+			eventField{"Operator", e.Operator},
+		)
+	case EventIPStuck:
+		return append(proxy,
+			eventField{"Stuck IP", e.NewIP},
+			eventField{"Duration", fmt.Sprintf("%d hours", e.Hours)},
+			eventField{"Country", e.Country},
+			eventField{"Operator", e.Operator},
+		)
+	case EventLowSpeed:
+		return append(proxy,
+			eventField{"Download", fmt.Sprintf("%d Mbps", e.Download)},
+			eventField{"Upload", fmt.Sprintf("%d Mbps", e.Upload)},
+			eventField{"Threshold", fmt.Sprintf("%d Mbps", e.Threshold)},
+		)
+	case EventOutage:
+		o := e.Outage
+		return []eventField{
+			{"Cause", outageCause(o)},
+			{"Down", fmt.Sprintf("%d of %d", o.Count, o.Total)},
+			{"Proxies", strings.Join(o.Names, "\n")},
+		}
+	case EventDailySummary, EventWeeklySummary:
+		s := e.Summary
+		return []eventField{
+			{"Total Proxies", fmt.Sprint(s.Total)},
+			{"Alive", fmt.Sprintf("%d (%.1f%%)", s.Alive, percent(s.Alive, s.Total))},
+			{"Dead", fmt.Sprintf("%d (%.1f%%)", s.Dead, percent(s.Dead, s.Total))},
+			{"Avg Speed", fmt.Sprintf("%.1f Mbps", s.AvgSpeed)},
+			{"Top Failing", fmt.Sprintf("%d failures total\n%s", s.Failures, formatProxyCounts(s.TopFailing))},
+			{"IP Rotations", fmt.Sprintf("%d total\n%s", s.TotalRotations, formatProxyCounts(s.Rotations))},
+			{"New Stuck IPs", formatStuckIPs(s.NewStuck)},
+		}
+	default:
+		return []eventField{{"Message", e.Message}}
+	}
+}
+
+// formatProxyCounts выводит список "имя: число" по строке на прокси.
+func formatProxyCounts(counts []ProxyCount) string {
+	lines := make([]string, 0, len(counts))
+	for _, c := range counts {
+		lines = append(lines, fmt.Sprintf("%s: %d", c.Name, c.Count))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatStuckIPs выводит список залипших IP.
+func formatStuckIPs(stuck []StuckIP) string {
+	lines := make([]string, 0, len(stuck))
+	for _, s := range stuck {
+		lines = append(lines, fmt.Sprintf("%s: %s since %s", s.Name, s.IP, s.Since.Format("2006-01-02 15:04")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// outageCause описывает общий признак упавшей группы.
+func outageCause(o *Outage) string {
+	switch o.GroupBy {
+	case OutageByChecker:
+		return "Checker connectivity loss"
+	case OutageByOperator:
+		return "Operator " + o.Group
+	case OutageByTag:
+		return "Tag " + o.Group
+	default:
+		return "Subnet " + o.Group
+	}
+}
+
+// isListField сообщает, что значение поля многострочное и выводится во всю ширину.
+func isListField(name string) bool {
+	switch name {
+	case "Error", "Message", "Proxies", "Top Failing", "IP Rotations", "New Stuck IPs":
+		return true
+	}
+	return false
+}
+
+// fieldValue заменяет пустое значение прочерком.
+func fieldValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
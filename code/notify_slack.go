@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SlackNotifier отправляет события во входящий вебхук Slack в виде Block Kit.
+type SlackNotifier struct {
+	WebhookURL string
+	Events     []string
+	client     *http.Client
+}
+
+func NewSlackNotifier(webhookURL string, events []string) *SlackNotifier {
+	return &SlackNotifier{
+		WebhookURL: webhookURL,
+		Events:     events,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *SlackNotifier) Name() string { return "slack" }
+
+func (s *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	if !wantsEvent(s.Events, event.Type) {
+		return nil
+	}
+
+	body, err := json.Marshal(formatSlack(event))
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack message: %w", err)
+	}
+
+	return deliverWithRetry(ctx, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return false, fmt.Errorf("failed to create slack request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return postOnce(s.client, req)
+	})
+}
+
+// slackMessage - тело запроса входящего вебхука. Text - запасной текст
+// для push-уведомлений, Blocks - разметка сообщения.
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Лимиты Block Kit: 10 полей в секции, 2000 символов в поле
+const (
+	slackMaxFields    = 10
+	slackMaxFieldText = 1900
+)
+
+func formatSlack(e Event) slackMessage {
+	title := eventTitle(e)
+
+	fields := make([]slackText, 0, slackMaxFields)
+	for _, f := range eventFields(e) {
+		if len(fields) == slackMaxFields {
+			break
+		}
+		value := escapeSlack(truncateRunes(fieldValue(f.Value), slackMaxFieldText))
+		if isListField(f.Name) {
+			value = "```" + value + "```"
+		}
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s:*\n%s", f.Name, value)})
+	}
+
+	when := e.Timestamp.Format("2006-01-02 15:04:05")
+	if e.Summary != nil {
+		when = e.Timestamp.Format("2006-01-02")
+	}
+
+	return slackMessage{
+		Text: fmt.Sprintf("%s: %s", title, escapeSlack(fieldValue(e.Name))),
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+			{Type: "section", Fields: fields},
+			{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: when}}},
+		},
+	}
+}
+
+// escapeSlack экранирует управляющие символы mrkdwn.
+func escapeSlack(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return s
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TelegramNotifier отправляет события в чат Telegram в виде HTML-сообщений.
+type TelegramNotifier struct {
+	Token  string
+	ChatID string
+	Events []string
+	client *http.Client
+}
+
+func NewTelegramNotifier(token, chatID string, events []string) *TelegramNotifier {
+	return &TelegramNotifier{
+		Token:  token,
+		ChatID: chatID,
+		Events: events,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// TelegramMessage represents a Telegram API message
+type TelegramMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+func (t *TelegramNotifier) Name() string { return "telegram" }
+
+func (t *TelegramNotifier) Notify(ctx context.Context, event Event) error {
+	if !wantsEvent(t.Events, event.Type) {
+		return nil
+	}
+	return t.Send(ctx, formatTelegram(event))
+}
+
+// Send sends a message to Telegram
+func (t *TelegramNotifier) Send(ctx context.Context, message string) error {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.Token)
+
+	msg := TelegramMessage{
+		ChatID:    t.ChatID,
+		Text:      message,
+		ParseMode: "HTML",
+	}
+
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal telegram message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create telegram request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send telegram message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram API returned status %d", resp.StatusCode)
+	}
+
+	log.Printf("Telegram notification sent successfully")
+	return nil
+}
+
+// formatTelegram рендерит событие в HTML для Telegram.
+func formatTelegram(e Event) string {
+	ts := e.Timestamp.Format("2006-01-02 15:04:05")
+
+	switch e.Type {
+	case EventProxyDown:
+		return fmt.Sprintf(
+			"🔴 <b>Proxy Down</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>IP:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Failures:</b> %d\n"+
+				"<b>Error:</b> %s\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.Failures, escapeHTML(e.Error), ts,
+		)
+	case EventProxyRecovered:
+		return fmt.Sprintf(
+			"🟢 <b>Proxy Recovered</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>IP:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Latency:</b> %d ms\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.Latency, ts,
+		)
+	case EventProxyFlapping:
+		return fmt.Sprintf(
+			"🟠 <b>Proxy Flapping</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>IP:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>State changes:</b> %d in %d min\n"+
+				"<b>Time:</b> %s\n\n"+
+				"Down/recovered notifications are paused until the proxy is stable.",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.Changes, e.Window, ts,
+		)
+	case EventOutage:
+		o := e.Outage
+		return fmt.Sprintf(
+			"🚨 <b>Mass Outage</b>\n\n"+
+				"<b>Cause:</b> %s\n"+
+				"<b>Down:</b> %d of %d\n"+
+				"<b>Time:</b> %s\n\n"+
+				"<b>Proxies:</b>\n%s",
+			escapeHTML(outageCause(o)), o.Count, o.Total, ts, escapeHTML(strings.Join(o.Names, "\n")),
+		)
+	case EventSLABreach:
+		return fmt.Sprintf(
+			"📉 <b>SLA Breach</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>IP:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Availability:</b> %.2f%%\n"+
+				"<b>SLA Target:</b> %.2f%%\n"+
+				"<b>Period:</b> %d days\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.Availability, e.SLATarget, e.SLAWindow, ts,
+		)
+	case EventIPChanged:
+		return fmt.Sprintf(
+			"🔄 <b>IP Changed</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>Proxy:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Old IP:</b> %s\n"+
+				"<b>New IP:</b> %s\n"+
+				"<b>Country:</b> %s\n"+
+				"<b>Operator:</b> %s\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.OldIP, e.NewIP,
+			escapeHTML(e.Country), escapeHTML(e.Operator), ts,
+		)
+	case EventIPStuck:
+		return fmt.Sprintf(
+			"⚠️ <b>IP Stuck</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>Proxy:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Stuck IP:</b> %s\n"+
+				"<b>Duration:</b> %d hours\n"+
+				"<b>Country:</b> %s\n"+
+				"<b>Operator:</b> %s\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.NewIP, e.Hours,
+			escapeHTML(e.Country), escapeHTML(e.Operator), ts,
+		)
+	case EventLowSpeed:
+		return fmt.Sprintf(
+			"🐌 <b>Low Speed Detected</b>\n\n"+
+				"<b>Name:</b> %s\n"+
+				"<b>Proxy:</b> %s\n"+
+				"<b>Username:</b> %s\n"+
+				"<b>Download:</b> %d Mbps\n"+
+				"<b>Upload:</b> %d Mbps\n"+
+				"<b>Threshold:</b> %d Mbps\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Name), e.Proxy, escapeHTML(e.Username), e.Download, e.Upload, e.Threshold, ts,
+		)
+	case EventDailySummary, EventWeeklySummary:
+		s := e.Summary
+		title := "Daily Proxy Summary"
+		if e.Type == EventWeeklySummary {
+			title = "Weekly Proxy Summary"
+		}
+		msg := fmt.Sprintf(
+			"📊 <b>%s</b>\n\n"+
+				"<b>Total Proxies:</b> %d\n"+
+				"<b>Alive:</b> %d (%.1f%%)\n"+
+				"<b>Dead:</b> %d (%.1f%%)\n"+
+				"<b>Avg Speed:</b> %.1f Mbps\n"+
+				"<b>Failures:</b> %d\n"+
+				"<b>IP Rotations:</b> %d\n"+
+				"<b>Period:</b> %s — %s",
+			title, s.Total, s.Alive, percent(s.Alive, s.Total), s.Dead, percent(s.Dead, s.Total),
+			s.AvgSpeed, s.Failures, s.TotalRotations,
+			s.From.Format("2006-01-02 15:04"), s.To.Format("2006-01-02 15:04"),
+		)
+		if len(s.TopFailing) > 0 {
+			msg += "\n\n<b>Top failing:</b>\n" + escapeHTML(formatProxyCounts(s.TopFailing))
+		}
+		if len(s.Rotations) > 0 {
+			msg += "\n\n<b>Most IP rotations:</b>\n" + escapeHTML(formatProxyCounts(s.Rotations))
+		}
+		if len(s.NewStuck) > 0 {
+			msg += "\n\n<b>New stuck IPs:</b>\n" + escapeHTML(formatStuckIPs(s.NewStuck))
+		}
+		return msg
+	default:
+		return fmt.Sprintf(
+			"🧪 <b>Test Notification</b>\n\n"+
+				"<b>Message:</b> %s\n"+
+				"<b>Time:</b> %s",
+			escapeHTML(e.Message), ts,
+		)
+	}
+}
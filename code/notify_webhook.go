@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Повторы доставки: 1s, 2s, 4s ... между попытками
+const (
+	webhookAttempts   = 4
+	webhookRetryDelay = time.Second
+)
+
+// Заголовки запроса вебхука
+const (
+	WebhookSignatureHeader = "X-Signature-256" // sha256=<hex HMAC тела>
+	WebhookEventHeader     = "X-Event-Type"
+	WebhookTimestampHeader = "X-Timestamp"
+)
+
+// WebhookTarget - адрес, на который отправляются события в JSON.
+type WebhookTarget struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"` // Ключ HMAC-SHA256 подписи (пусто - без подписи)
+	Enabled bool     `json:"enabled"`
+	Events  []string `json:"events"` // Типы событий (пусто - все)
+}
+
+// WebhookNotifier отправляет Event как есть в JSON, подписывая тело HMAC.
+type WebhookNotifier struct {
+	target WebhookTarget
+	client *http.Client
+}
+
+func NewWebhookNotifier(target WebhookTarget) *WebhookNotifier {
+	return &WebhookNotifier{
+		target: target,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (w *WebhookNotifier) Name() string {
+	if w.target.Name != "" {
+		return "webhook " + w.target.Name
+	}
+	return "webhook " + w.target.URL
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	if !wantsEvent(w.target.Events, event.Type) {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	return deliverWithRetry(ctx, func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.target.URL, bytes.NewReader(body))
+		if err != nil {
+			return false, fmt.Errorf("failed to create webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookEventHeader, event.Type)
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(event.Timestamp.Unix(), 10))
+		if w.target.Secret != "" {
+			req.Header.Set(WebhookSignatureHeader, signWebhook(w.target.Secret, body))
+		}
+		return postOnce(w.client, req)
+	})
+}
+
+// signWebhook возвращает подпись тела в формате "sha256=<hex>".
+// Получатель считает HMAC-SHA256 от сырого тела с тем же секретом.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// wantsEvent сообщает, подписан ли канал на тип события (пустой список - на все).
+// Тестовые уведомления доставляются всегда.
+func wantsEvent(events []string, eventType string) bool {
+	return len(events) == 0 || eventType == EventTest || slices.Contains(events, eventType)
+}
+
+// postOnce выполняет запрос и решает, стоит ли повторять: повторяем сетевые
+// ошибки, 429 и 5xx, а остальные ответы 4xx считаем окончательными.
+func postOnce(client *http.Client, req *http.Request) (retry bool, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// deliverWithRetry повторяет попытку с экспоненциальной задержкой, пока она
+// просит повтора, попытки не кончились и ctx не отменен.
+func deliverWithRetry(ctx context.Context, attempt func() (retry bool, err error)) error {
+	delay := webhookRetryDelay
+	var err error
+	for i := 0; i < webhookAttempts; i++ {
+		var retry bool
+		if retry, err = attempt(); err == nil || !retry {
+			return err
+		}
+		if i == webhookAttempts-1 {
+			break
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", webhookAttempts, err)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Признаки, по которым группируются одновременные падения
+const (
+	OutageByChecker  = "checker"  // пропала связь у самого чекера
+	OutageByOperator = "operator" // авария у мобильного оператора
+	OutageByTag      = "tag"
+	OutageBySubnet   = "subnet" // /24 адреса прокси
+)
+
+// Значения по умолчанию для корреляции падений
+const (
+	defaultOutageWindowSeconds  = 60
+	defaultOutageMinProxies     = 5
+	defaultOutageCheckerPercent = 80
+)
+
+// Сколько имен прокси показывать в групповом сообщении
+const outageMaxNames = 20
+
+// downReport - падение прокси, ожидающее группировки.
+type downReport struct {
+	proxy  Proxy
+	errMsg string
+}
+
+// Outage - группа прокси, упавших одновременно по общей причине.
+type Outage struct {
+	GroupBy string   `json:"group_by"`
+	Group   string   `json:"group"`
+	Count   int      `json:"count"`
+	Total   int      `json:"total"` // прокси в группе всего
+	Names   []string `json:"names"`
+}
+
+// outageCorrelator копит алерты о падении в течение окна и отправляет
+// падения с общим оператором, тегом или подсетью одним сообщением.
+type outageCorrelator struct {
+	db       *gorm.DB
+	notifier *NotificationService
+
+	mu      sync.Mutex
+	pending []downReport
+	stg     *Settings
+	timer   *time.Timer
+}
+
+// add ставит падение в очередь. Первое падение открывает окно группировки
+// длиной в интервал проверки, см. outageWindow.
+func (c *outageCorrelator) add(p Proxy, errMsg string, stg *Settings) {
+	if !stg.OutageGrouping {
+		c.notifier.NotifyProxyDown(&p, errMsg)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, downReport{proxy: p, errMsg: errMsg})
+	c.stg = stg
+	if c.timer == nil {
+		c.timer = time.AfterFunc(outageWindow(&p, stg), c.flush)
+	}
+}
+
+// flush группирует накопленные падения и отправляет уведомления.
+func (c *outageCorrelator) flush() {
+	c.mu.Lock()
+	pending, stg := c.pending, c.stg
+	c.pending, c.timer = nil, nil
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	var proxies []Proxy
+	if err := c.db.Find(&proxies).Error; err != nil {
+		log.Printf("Alerts: failed to load proxies for outage correlation: %v", err)
+	}
+	outages, single := correlateOutages(pending, proxies, stg)
+	for _, o := range outages {
+		log.Printf("Alerts: %s outage %q - %d of %d proxies down", o.GroupBy, o.Group, o.Count, o.Total)
+		c.notifier.NotifyOutage(o)
+	}
+	for _, r := range single {
+		c.notifier.NotifyProxyDown(&r.proxy, r.errMsg)
+	}
+}
+
+// correlateOutages делит падения на групповые аварии и одиночные алерты.
+// Если упала большая часть парка, причина скорее на стороне чекера, и все
+// падения сворачиваются в одну аварию. Иначе каждый прокси попадает в самую
+// крупную группу, набравшую OutageMinProxies.
+func correlateOutages(reports []downReport, fleet []Proxy, stg *Settings) ([]Outage, []downReport) {
+	minProxies, checkerPercent := outageLimits(stg)
+	if len(reports) < minProxies {
+		return nil, reports
+	}
+
+	// Доля считается от прокси, которые были живы до этого окна
+	reported := make(map[string]bool, len(reports))
+	for _, r := range reports {
+		reported[r.proxy.Id] = true
+	}
+	alive := len(reports)
+	for _, p := range fleet {
+		if p.LastStatus != 2 && !reported[p.Id] {
+			alive++
+		}
+	}
+	if percent(len(reports), alive) >= float64(checkerPercent) {
+		return []Outage{newOutage(OutageByChecker, "all proxies", reports, alive)}, nil
+	}
+
+	type group struct {
+		by, key string
+		members []int
+	}
+	groups := make(map[string]*group)
+	add := func(by, key string, i int) {
+		if key == "" {
+			return
+		}
+		id := by + "\x00" + key
+		g := groups[id]
+		if g == nil {
+			g = &group{by: by, key: key}
+			groups[id] = g
+		}
+		g.members = append(g.members, i)
+	}
+	for i, r := range reports {
+		add(OutageByOperator, r.proxy.Operator, i)
+		add(OutageByTag, r.proxy.Tag, i)
+		add(OutageBySubnet, subnet24(r.proxy.Ip), i)
+	}
+
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		if len(g.members) >= minProxies {
+			sorted = append(sorted, g)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].members) != len(sorted[j].members) {
+			return len(sorted[i].members) > len(sorted[j].members)
+		}
+		return sorted[i].by+sorted[i].key < sorted[j].by+sorted[j].key
+	})
+
+	assigned := make([]bool, len(reports))
+	var outages []Outage
+	for _, g := range sorted {
+		var members []downReport
+		for _, i := range g.members {
+			if !assigned[i] {
+				members = append(members, reports[i])
+			}
+		}
+		if len(members) < minProxies {
+			continue
+		}
+		for _, i := range g.members {
+			assigned[i] = true
+		}
+		outages = append(outages, newOutage(g.by, g.key, members, groupSize(fleet, g.by, g.key)))
+	}
+
+	var single []downReport
+	for i, r := range reports {
+		if !assigned[i] {
+			single = append(single, r)
+		}
+	}
+	return outages, single
+}
+
+func newOutage(by, key string, reports []downReport, total int) Outage {
+	names := make([]string, 0, len(reports))
+	for _, r := range reports {
+		names = append(names, proxyDisplayName(&r.proxy))
+	}
+	sort.Strings(names)
+	if len(names) > outageMaxNames {
+		names = append(names[:outageMaxNames], fmt.Sprintf("… and %d more", len(reports)-outageMaxNames))
+	}
+	return Outage{GroupBy: by, Group: key, Count: len(reports), Total: total, Names: names}
+}
+
+// groupSize считает прокси парка с тем же признаком группы.
+func groupSize(fleet []Proxy, by, key string) int {
+	n := 0
+	for _, p := range fleet {
+		switch {
+		case by == OutageByOperator && p.Operator == key,
+			by == OutageByTag && p.Tag == key,
+			by == OutageBySubnet && subnet24(p.Ip) == key:
+			n++
+		}
+	}
+	return n
+}
+
+// subnet24 возвращает подсеть /24 для IPv4-адреса, для остальных - пустую строку.
+func subnet24(addr string) string {
+	ip := net.ParseIP(addr).To4()
+	if ip == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.0/24", ip[0], ip[1], ip[2])
+}
+
+// outageWindow возвращает, сколько копить падения, начиная с первого (p).
+// Проверки IP распределены по всему интервалу с jitter, а алерт о падении
+// приходит после нескольких ошибок подряд, поэтому падения одной аварии
+// доходят до группировки в течение целого интервала проверки. Окно не
+// короче интервала проверки с максимальным jitter; OutageWindowSeconds -
+// нижняя граница.
+func outageWindow(p *Proxy, stg *Settings) time.Duration {
+	seconds := stg.OutageWindowSeconds
+	if seconds <= 0 {
+		seconds = defaultOutageWindowSeconds
+	}
+	window := time.Duration(seconds) * time.Second
+	return max(window, maxJittered(checkInterval(p, stg), stg))
+}
+
+// outageLimits возвращает размер группы и долю парка для аварии чекера
+// с учетом значений по умолчанию.
+func outageLimits(stg *Settings) (minProxies, checkerPercent int) {
+	minProxies = stg.OutageMinProxies
+	if minProxies < 2 {
+		minProxies = defaultOutageMinProxies
+	}
+	checkerPercent = stg.OutageCheckerPercent
+	if checkerPercent <= 0 || checkerPercent > 100 {
+		checkerPercent = defaultOutageCheckerPercent
+	}
+	return minProxies, checkerPercent
+}
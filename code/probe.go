@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Имена встроенных проб, используются в Settings.ProbeSet
+const (
+	ProbeTCP          = "tcp"
+	ProbeHTTP         = "http"
+	ProbeHTTPSConnect = "https_connect"
+
+	defaultProbeSet = ProbeHTTP
+	// Ограничение на размер тела ответа, которое читается для проверки содержимого
+	maxProbeBodySize = 1 << 20
+)
+
+// ProbeResult содержит результат одной пробы и длительность ее фаз.
+// Фазы, которые проба не выполняет, остаются нулевыми.
+type ProbeResult struct {
+	Probe      string        `json:"probe"`
+	Total      time.Duration `json:"total"`
+	Connect    time.Duration `json:"connect"`
+	TLS        time.Duration `json:"tls"`
+	TTFB       time.Duration `json:"ttfb"`
+	StatusCode int           `json:"status_code,omitempty"`
+}
+
+// Probe проверяет доступность прокси одним способом.
+type Probe interface {
+	Name() string
+	Run(ctx context.Context, proxy *Proxy, stg *Settings) (ProbeResult, error)
+}
+
+var probeRegistry = map[string]func() Probe{
+	ProbeTCP:          func() Probe { return tcpProbe{} },
+	ProbeHTTP:         func() Probe { return httpProbe{} },
+	ProbeHTTPSConnect: func() Probe { return httpsConnectProbe{} },
+}
+
+// parseProbeSet превращает строку вида "tcp,http" в список проб.
+// Неизвестные имена пропускаются, пустой набор заменяется набором по умолчанию.
+func parseProbeSet(set string) []Probe {
+	var probes []Probe
+	for _, name := range strings.Split(set, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		factory, ok := probeRegistry[name]
+		if !ok {
+			log.Printf("Unknown probe %q in probe set, skipping", name)
+			continue
+		}
+		probes = append(probes, factory())
+	}
+	if len(probes) == 0 {
+		probes = append(probes, probeRegistry[defaultProbeSet]())
+	}
+	return probes
+}
+
+// RunProbes последовательно выполняет все пробы из Settings.ProbeSet.
+// Прокси считается живым, только если прошли все пробы.
+func RunProbes(ctx context.Context, stg *Settings, proxy *Proxy) ([]ProbeResult, error) {
+	probes := runnableProbes(stg)
+	results := make([]ProbeResult, 0, len(probes))
+
+	for _, probe := range probes {
+		res, err := probe.Run(ctx, proxy, stg)
+		res.Probe = probe.Name()
+		results = append(results, res)
+		if err != nil {
+			return results, fmt.Errorf("%s probe failed: %w", probe.Name(), err)
+		}
+		log.Printf("Probe %s for %s:%s - total: %v, connect: %v, tls: %v, ttfb: %v",
+			res.Probe, proxy.Ip, proxy.Port, res.Total, res.Connect, res.TLS, res.TTFB)
+	}
+
+	return results, nil
+}
+
+// runnableProbes возвращает пробы набора, которым хватает настроек.
+// https_connect без https-адреса ничего не говорит о прокси: новые
+// настройки такое не сохранят, а в старых проба пропускается.
+func runnableProbes(stg *Settings) []Probe {
+	var probes []Probe
+	for _, probe := range parseProbeSet(stg.ProbeSet) {
+		if probe.Name() == ProbeHTTPSConnect {
+			if _, _, err := httpsProbeTarget(stg); err != nil {
+				continue
+			}
+		}
+		probes = append(probes, probe)
+	}
+	if len(probes) == 0 {
+		probes = append(probes, probeRegistry[defaultProbeSet]())
+	}
+	return probes
+}
+
+// probeLatency возвращает задержку первой пробы набора в миллисекундах.
+func probeLatency(results []ProbeResult) int {
+	if len(results) == 0 {
+		return 0
+	}
+	return int(results[0].Total.Milliseconds())
+}
+
+// tcpProbe проверяет, что порт прокси принимает TCP-соединения.
+type tcpProbe struct{}
+
+func (tcpProbe) Name() string { return ProbeTCP }
+
+func (tcpProbe) Run(ctx context.Context, proxy *Proxy, stg *Settings) (ProbeResult, error) {
+	var res ProbeResult
+	dialer := &net.Dialer{Timeout: time.Duration(stg.Timeout) * time.Second}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxy.Ip, proxy.Port))
+	res.Connect = time.Since(start)
+	res.Total = res.Connect
+	if err != nil {
+		return res, err
+	}
+	conn.Close()
+
+	return res, nil
+}
+
+// httpProbe выполняет GET на Settings.Url через прокси и проверяет
+// код ответа и, если задано, наличие подстроки в теле.
+type httpProbe struct{}
+
+func (httpProbe) Name() string { return ProbeHTTP }
+
+func (httpProbe) Run(ctx context.Context, proxy *Proxy, stg *Settings) (res ProbeResult, err error) {
+	client, err := newProxyClient(proxy, stg)
+	if err != nil {
+		return res, err
+	}
+
+	var connectStart, tlsStart time.Time
+	start := time.Now()
+	defer func() { res.Total = time.Since(start) }()
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			if !connectStart.IsZero() {
+				res.Connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if !tlsStart.IsZero() {
+				res.TLS = time.Since(tlsStart)
+			}
+		},
+		GotFirstResponseByte: func() { res.TTFB = time.Since(start) },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, stg.Url, nil)
+	if err != nil {
+		return res, err
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer rsp.Body.Close()
+
+	res.StatusCode = rsp.StatusCode
+	if stg.ProbeExpectedStatus > 0 && rsp.StatusCode != stg.ProbeExpectedStatus {
+		return res, fmt.Errorf("unexpected status %d, expected %d", rsp.StatusCode, stg.ProbeExpectedStatus)
+	}
+	if stg.ProbeExpectedStatus <= 0 && rsp.StatusCode >= http.StatusBadRequest {
+		return res, fmt.Errorf("unexpected status %d", rsp.StatusCode)
+	}
+
+	if stg.ProbeExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(rsp.Body, maxProbeBodySize))
+		if err != nil {
+			return res, fmt.Errorf("failed to read response body: %w", err)
+		}
+		if !strings.Contains(string(body), stg.ProbeExpectedBody) {
+			return res, errors.New("response body does not contain expected text")
+		}
+	}
+
+	return res, nil
+}
+
+// httpsConnectProbe открывает туннель через прокси до хоста из
+// Settings.ProbeHTTPSURL (или Settings.Url, если это https) и выполняет
+// TLS-рукопожатие с ним.
+type httpsConnectProbe struct{}
+
+// httpsProbeTarget возвращает адрес host:port для https_connect. Для
+// http-адреса TLS-рукопожатие заведомо не пройдет, поэтому это ошибка
+// настройки, а не падение прокси.
+func httpsProbeTarget(stg *Settings) (host, addr string, err error) {
+	raw := stg.ProbeHTTPSURL
+	if raw == "" {
+		raw = stg.Url
+	}
+	target, err := url.Parse(raw)
+	if err != nil || target.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid probe url %q", raw)
+	}
+	if target.Scheme != "https" {
+		return "", "", fmt.Errorf("https_connect probe needs an https:// url, got %q: set probeHttpsUrl", raw)
+	}
+	port := target.Port()
+	if port == "" {
+		port = "443"
+	}
+	return target.Hostname(), net.JoinHostPort(target.Hostname(), port), nil
+}
+
+// validateProbeSet проверяет, что выбранным пробам хватает настроек.
+func validateProbeSet(stg *Settings) error {
+	for _, probe := range parseProbeSet(stg.ProbeSet) {
+		if probe.Name() == ProbeHTTPSConnect {
+			if _, _, err := httpsProbeTarget(stg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (httpsConnectProbe) Name() string { return ProbeHTTPSConnect }
+
+func (httpsConnectProbe) Run(ctx context.Context, proxy *Proxy, stg *Settings) (ProbeResult, error) {
+	var res ProbeResult
+
+	host, addr, err := httpsProbeTarget(stg)
+	if err != nil {
+		return res, err
+	}
+
+	if stg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(stg.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	start := time.Now()
+	conn, err := dialThroughProxy(ctx, proxy, stg, addr)
+	res.Connect = time.Since(start)
+	if err != nil {
+		res.Total = res.Connect
+		return res, err
+	}
+	defer conn.Close()
+
+	tlsStart := time.Now()
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: stg.SkipSSLVerify})
+	err = tlsConn.HandshakeContext(ctx)
+	res.TLS = time.Since(tlsStart)
+	res.Total = time.Since(start)
+	if err != nil {
+		return res, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	return res, nil
+}
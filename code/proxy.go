@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,45 +14,49 @@ import (
 	"gorm.io/gorm"
 )
 
-// CheckSpeed измеряет скорость загрузки через прокси в КБ/с.
-// Для точного измерения скорости рекомендуется использовать URL-адрес
-// для `settings.Url`, который отдает файл размером не менее нескольких мегабайт.
+// CheckSpeed измеряет скорость загрузки и отдачи через прокси в Мбит/с.
+// Если в настройках задан SpeedTestURL, замер идет против собственного
+// сервера (см. target_server.go), иначе - против публичных серверов speedtest.net.
 func CheckSpeed(settings *Settings, proxy *Proxy, db *gorm.DB) (float64, float64, error) {
+	speedTests.acquire(jobWorkers(settings, JobSpeedTest))
+	defer speedTests.release()
+
 	client, err := newProxyClient(proxy, settings)
 	if err != nil {
 		return 0, 0, err
 	}
-	var speedtestClient = speedtest.New(speedtest.WithDoer(client))
-	serverList, _ := speedtestClient.FetchServers()
-	targets, _ := serverList.FindServer([]int{})
-	if len(targets) == 0 {
-		return 0, 0, errors.New("no suitable servers found")
+
+	var runTest func() (float64, float64, float64, error)
+	if settings.SpeedTestURL != "" {
+		runTest = func() (float64, float64, float64, error) {
+			return selfHostedSpeedTest(client, settings)
+		}
+	} else {
+		tg, err := findSpeedtestServer(client)
+		if err != nil {
+			return 0, 0, err
+		}
+		runTest = func() (float64, float64, float64, error) {
+			return speedtestNetTest(tg)
+		}
 	}
-	tg := targets[0]
 
-	// Run ping test with callback
-	err = tg.PingTest(func(latency time.Duration) {})
+	ping, download, upload, err := runTest()
 	if err != nil {
 		return 0, 0, err
 	}
 
-	tg.DownloadTest()
-	tg.UploadTest()
-
-	ping := float64(tg.Latency.Milliseconds())
-	upload := tg.ULSpeed.Mbps()
-	download := tg.DLSpeed.Mbps()
-
-	// Retry once if download or upload is 0
-	if download == 0 || upload == 0 {
+	// Retry once if download or upload is 0 (upload is not measured when
+	// the self-hosted target has no upload sink)
+	measuresUpload := settings.SpeedTestURL == "" || settings.SpeedTestUploadURL != ""
+	if download == 0 || (upload == 0 && measuresUpload) {
 		log.Printf("Speedtest retry for %s:%s - Download: %.2f Mbps, Upload: %.2f Mbps (retrying once)",
 			proxy.Ip, proxy.Port, download, upload)
 
-		tg.DownloadTest()
-		tg.UploadTest()
-
-		upload = tg.ULSpeed.Mbps()
-		download = tg.DLSpeed.Mbps()
+		if _, retryDownload, retryUpload, err := runTest(); err == nil {
+			download = retryDownload
+			upload = retryUpload
+		}
 	}
 
 	log.Printf("Speedtest results for %s:%s - Ping: %.2fms, Download: %.2f Mbps, Upload: %.2f Mbps",
@@ -78,27 +86,110 @@ func CheckSpeed(settings *Settings, proxy *Proxy, db *gorm.DB) (float64, float64
 	return download, upload, nil
 }
 
-func Ping(settings *Settings, proxy *Proxy) (int, error) {
-	client, err := newProxyClient(proxy, settings)
-	if err != nil {
-		return 0, err
-	}
-
+// findSpeedtestServer выбирает ближайший сервер speedtest.net через прокси.
+func findSpeedtestServer(client *http.Client) (*speedtest.Server, error) {
 	var speedtestClient = speedtest.New(speedtest.WithDoer(client))
 	serverList, _ := speedtestClient.FetchServers()
 	targets, _ := serverList.FindServer([]int{})
 	if len(targets) == 0 {
-		return 0, errors.New("no suitable servers found")
+		return nil, errors.New("no suitable servers found")
 	}
-	tg := targets[0]
+	return targets[0], nil
+}
 
+// speedtestNetTest возвращает пинг (мс), загрузку и отдачу (Мбит/с) по серверу speedtest.net.
+func speedtestNetTest(tg *speedtest.Server) (float64, float64, float64, error) {
 	// Run ping test with callback
-	err = tg.PingTest(func(latency time.Duration) {})
+	if err := tg.PingTest(func(latency time.Duration) {}); err != nil {
+		return 0, 0, 0, err
+	}
+
+	tg.DownloadTest()
+	tg.UploadTest()
+
+	return float64(tg.Latency.Milliseconds()), tg.DLSpeed.Mbps(), tg.ULSpeed.Mbps(), nil
+}
+
+// selfHostedSpeedTest замеряет скорость по собственному серверу: скачивает
+// SpeedTestURL и отправляет SpeedTestSizeMB мегабайт на SpeedTestUploadURL.
+// Пингом считается время до первого байта ответа на загрузку.
+func selfHostedSpeedTest(client *http.Client, settings *Settings) (float64, float64, float64, error) {
+	// Замер идет дольше обычной проверки, поэтому общий таймаут клиента не используется,
+	// а ограничение задается контекстом.
+	speedClient := *client
+	speedClient.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), speedTestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, settings.SpeedTestURL, nil)
 	if err != nil {
-		return 0, err
+		return 0, 0, 0, err
 	}
 
-	ping := int(tg.Latency.Milliseconds())
+	start := time.Now()
+	rsp, err := speedClient.Do(req)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("download request failed: %w", err)
+	}
+	ping := float64(time.Since(start).Milliseconds())
 
-	return ping, nil
+	bodyStart := time.Now()
+	n, err := io.Copy(io.Discard, rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("download failed: %w", err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return 0, 0, 0, fmt.Errorf("download URL returned status %d", rsp.StatusCode)
+	}
+	download := mbps(n, time.Since(bodyStart))
+
+	var upload float64
+	if settings.SpeedTestUploadURL != "" {
+		sizeMB := settings.SpeedTestSizeMB
+		if sizeMB <= 0 {
+			sizeMB = defaultSpeedTestSizeMB
+		}
+		// Сервер замера не принимает больше maxSpeedTestSizeMB за запрос
+		size := int64(min(sizeMB, maxSpeedTestSizeMB)) * 1024 * 1024
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.SpeedTestUploadURL, io.LimitReader(&chunkReader{}, size))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		uploadStart := time.Now()
+		rsp, err := speedClient.Do(req)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("upload failed: %w", err)
+		}
+		io.Copy(io.Discard, rsp.Body)
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			return 0, 0, 0, fmt.Errorf("upload URL returned status %d", rsp.StatusCode)
+		}
+		upload = mbps(size, time.Since(uploadStart))
+	}
+
+	return ping, download, upload, nil
+}
+
+// mbps переводит количество байт за время d в мегабиты в секунду.
+func mbps(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / 1e6 / d.Seconds()
+}
+
+// Ping проверяет доступность прокси набором проб из настроек
+// и возвращает задержку первой пробы в миллисекундах.
+func Ping(settings *Settings, proxy *Proxy) (int, error) {
+	results, err := RunProbes(context.Background(), settings, proxy)
+	if err != nil {
+		return 0, err
+	}
+	return probeLatency(results), nil
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Периоды отчетов
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// Сколько прокси показывать в списках отчета
+const reportTopN = 5
+
+// ProxyCount - прокси и число событий за период (ошибок или смен IP).
+type ProxyCount struct {
+	ProxyID string `json:"proxy_id"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+}
+
+// StuckIP - прокси, IP которого "залип" в течение периода.
+type StuckIP struct {
+	ProxyID string    `json:"proxy_id"`
+	Name    string    `json:"name"`
+	IP      string    `json:"ip"`
+	Since   time.Time `json:"since"` // с какого момента IP не меняется
+}
+
+// SummaryData - данные сводки за период.
+type SummaryData struct {
+	Period string    `json:"period"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+
+	Total    int     `json:"total"`
+	Alive    int     `json:"alive"`
+	Dead     int     `json:"dead"`
+	AvgSpeed float64 `json:"avg_speed"` // по замерам за период, Mbps
+
+	TopFailing     []ProxyCount `json:"top_failing"`
+	Failures       int          `json:"failures"`
+	Rotations      []ProxyCount `json:"rotations"` // больше всего смен IP
+	TotalRotations int          `json:"total_rotations"`
+	NewStuck       []StuckIP    `json:"new_stuck"`
+}
+
+// BuildSummary собирает сводку за период [from, to).
+func BuildSummary(db *gorm.DB, period string, from, to time.Time) (*SummaryData, error) {
+	// Время в логах хранится в локальной зоне сервера, сравниваем в ней же
+	from, to = from.In(time.Local), to.In(time.Local)
+	s := &SummaryData{Period: period, From: from, To: to}
+
+	var proxies []Proxy
+	if err := db.Find(&proxies).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(proxies))
+	for _, p := range proxies {
+		names[p.Id] = proxyDisplayName(&p)
+		s.Total++
+		if p.LastStatus == 2 {
+			s.Dead++
+		} else if p.LastStatus == 1 {
+			s.Alive++
+		}
+
+		// IP считается залипшим через stuckIPThreshold после последней смены
+		if p.Stack && !p.LastIPChange.IsZero() {
+			since := p.LastIPChange
+			if stuckAt := since.Add(stuckIPThreshold); !stuckAt.Before(from) && stuckAt.Before(to) {
+				s.NewStuck = append(s.NewStuck, StuckIP{ProxyID: p.Id, Name: names[p.Id], IP: p.RealIP, Since: since})
+			}
+		}
+	}
+	sort.Slice(s.NewStuck, func(i, j int) bool { return s.NewStuck[i].Since.Before(s.NewStuck[j].Since) })
+
+	var avg struct{ Speed float64 }
+	if err := db.Model(&ProxySpeedLog{}).
+		Select("AVG(speed) AS speed").
+		Where("timestamp >= ? AND timestamp < ? AND speed > 0", from, to).
+		Scan(&avg).Error; err != nil {
+		return nil, err
+	}
+	s.AvgSpeed = avg.Speed
+
+	var err error
+	if s.TopFailing, s.Failures, err = countByProxy(db, &ProxyFailureLog{}, from, to, names); err != nil {
+		return nil, err
+	}
+	if s.Rotations, s.TotalRotations, err = countByProxy(db, &ProxyIPLog{}, from, to, names); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// countByProxy считает строки лога за период по прокси и возвращает топ и общее число.
+func countByProxy(db *gorm.DB, model any, from, to time.Time, names map[string]string) ([]ProxyCount, int, error) {
+	var rows []struct {
+		ProxyID string
+		Count   int
+	}
+	err := db.Model(model).
+		Select("proxy_id, COUNT(*) AS count").
+		Where("timestamp >= ? AND timestamp < ?", from, to).
+		Group("proxy_id").
+		Order("count DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := 0
+	top := []ProxyCount{}
+	for _, r := range rows {
+		total += r.Count
+		name, ok := names[r.ProxyID]
+		if !ok {
+			continue // прокси удален
+		}
+		if len(top) < reportTopN {
+			top = append(top, ProxyCount{ProxyID: r.ProxyID, Name: name, Count: r.Count})
+		}
+	}
+	return top, total, nil
+}
+
+// proxyDisplayName - имя прокси для отчетов, без имени - адрес.
+func proxyDisplayName(p *Proxy) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Ip + ":" + p.Port
+}
+
+// reportLocation возвращает часовой пояс отчетов (по умолчанию - локальный).
+func reportLocation(stg *Settings) *time.Location {
+	if stg.ReportTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(stg.ReportTimezone)
+	if err != nil {
+		log.Printf("Report: unknown timezone %q, using local time: %v", stg.ReportTimezone, err)
+		return time.Local
+	}
+	return loc
+}
+
+// validateReportSchedule проверяет время, день недели и часовой пояс отчетов.
+func validateReportSchedule(stg *Settings) error {
+	if _, _, err := parseReportTime(stg.DailySummaryTime); err != nil {
+		return fmt.Errorf("dailySummaryTime: %w", err)
+	}
+	if stg.WeeklySummaryDay < 0 || stg.WeeklySummaryDay > 6 {
+		return fmt.Errorf("weeklySummaryDay must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	if stg.ReportTimezone != "" {
+		if _, err := time.LoadLocation(stg.ReportTimezone); err != nil {
+			return fmt.Errorf("unknown reportTimezone %q", stg.ReportTimezone)
+		}
+	}
+	return nil
+}
+
+// parseReportTime разбирает время "HH:MM".
+func parseReportTime(value string) (hour, minute int, err error) {
+	h, m, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	if hour, err = strconv.Atoi(h); err != nil || hour < 0 || hour > 23 {
+		return 0, 0, fmt.Errorf("invalid hour in %q", value)
+	}
+	if minute, err = strconv.Atoi(m); err != nil || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid minute in %q", value)
+	}
+	return hour, minute, nil
+}
+
+// nextReportTime возвращает ближайший момент после now, когда наступает
+// DailySummaryTime в часовом поясе отчетов.
+func nextReportTime(stg *Settings, now time.Time) (time.Time, error) {
+	hour, minute, err := parseReportTime(stg.DailySummaryTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	local := now.In(reportLocation(stg))
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, local.Location())
+	if !next.After(local) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, local.Location())
+	}
+	return next, nil
+}
+
+// reportsEnabled сообщает, включен ли хотя бы один отчет.
+func reportsEnabled(stg *Settings) bool {
+	return stg.NotifyDailySummary || stg.NotifyWeeklySummary
+}
+
+// runReports отправляет ежедневную и еженедельную сводку в настроенное время.
+// reset будит цикл после изменения настроек.
+func (m *SchedulerManager) runReports(wg *sync.WaitGroup, quit <-chan struct{}, reset <-chan struct{}) {
+	defer wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	var due time.Time
+	schedule := func() {
+		timer.Stop()
+		due = time.Time{}
+		stg := m.Settings()
+		if !reportsEnabled(stg) {
+			return
+		}
+		next, err := nextReportTime(stg, time.Now())
+		if err != nil {
+			log.Printf("Report: %v, summary is not scheduled", err)
+			return
+		}
+		due = next
+		timer.Reset(time.Until(next))
+		log.Printf("Report: next summary at %s", next.Format("2006-01-02 15:04 MST"))
+	}
+
+	schedule()
+	for {
+		select {
+		case <-timer.C:
+			m.sendReports(due)
+			schedule()
+		case <-reset:
+			schedule()
+		case <-quit:
+			log.Println("Report: Shutting down summary scheduler.")
+			return
+		}
+	}
+}
+
+// sendReports строит и отправляет отчеты, наступившие в момент at.
+func (m *SchedulerManager) sendReports(at time.Time) {
+	stg := m.Settings()
+
+	if stg.NotifyDailySummary {
+		m.sendReport(ReportDaily, at.AddDate(0, 0, -1), at)
+	}
+	if stg.NotifyWeeklySummary && int(at.Weekday()) == stg.WeeklySummaryDay {
+		m.sendReport(ReportWeekly, at.AddDate(0, 0, -7), at)
+	}
+}
+
+func (m *SchedulerManager) sendReport(period string, from, to time.Time) {
+	summary, err := BuildSummary(m.db, period, from, to)
+	if err != nil {
+		log.Printf("Report: failed to build %s summary: %v", period, err)
+		return
+	}
+	log.Printf("Report: sending %s summary (%d proxies, %d alive, %d dead)", period, summary.Total, summary.Alive, summary.Dead)
+	m.notifier.NotifySummary(summary)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Имена задач планировщика
+const (
+	JobIPCheck     = "ip_check"
+	JobHealthCheck = "health_check"
+	JobSLACheck    = "sla_check"
+)
+
+// schedulerJob - периодическая задача. Интервал вычисляется из текущих настроек,
+// поэтому после UpdateSettings тикер перезапускается без рестарта приложения.
+type schedulerJob struct {
+	name     string
+	interval func(*Settings) time.Duration
+	run      func(ctx context.Context, stg *Settings)
+
+	running sync.Mutex    // не дает запустить задачу, пока идет предыдущий цикл
+	reset   chan struct{} // сигнал о смене настроек
+}
+
+// SchedulerManager владеет настройками планировщиков и реагирует на их изменение:
+// меняет интервалы, включает/выключает задачи (интервал <= 0 - выключена) и
+// применяет новое число воркеров со следующего цикла. Уже идущие проверки
+// дорабатывают со снимком настроек, с которым были запущены.
+type SchedulerManager struct {
+	db       *gorm.DB
+	geoIP    *GeoIPClient
+	notifier *NotificationService
+	alerts   *AlertManager
+	guard    *ConnectivityGuard
+
+	mu       sync.RWMutex
+	settings *Settings
+	jobs     []*schedulerJob
+
+	// Проверки IP идут по очереди с собственным расписанием каждого прокси
+	ipQueue *CheckQueue
+
+	reportReset chan struct{} // сигнал планировщику отчетов о смене настроек
+
+	stats         jobStatsStore
+	healthWorkers adaptiveWorkers
+	healthLoad    float64 // нагрузка прошлого цикла health check (цикл / интервал)
+}
+
+func NewSchedulerManager(db *gorm.DB, settings *Settings, geoIP *GeoIPClient, notifier *NotificationService) *SchedulerManager {
+	m := &SchedulerManager{
+		db:       db,
+		geoIP:    geoIP,
+		notifier: notifier,
+		alerts:   NewAlertManager(db, notifier),
+		guard:    NewConnectivityGuard(),
+		settings: settings,
+
+		reportReset: make(chan struct{}, 1),
+	}
+	m.ipQueue = NewCheckQueue(db, geoIP, m.alerts, m.guard, m.Settings, &m.stats)
+	m.jobs = []*schedulerJob{
+		{
+			name:     JobHealthCheck,
+			interval: func(s *Settings) time.Duration { return time.Duration(s.SpeedCheckInterval) * time.Minute },
+			run:      m.runHealthCheck,
+			reset:    make(chan struct{}, 1),
+		},
+		{
+			name:     JobSLACheck,
+			interval: func(*Settings) time.Duration { return slaCheckInterval },
+			run:      m.runSLACheck,
+			reset:    make(chan struct{}, 1),
+		},
+	}
+	return m
+}
+
+// Start запускает цикл каждой задачи и сразу возвращает управление.
+func (m *SchedulerManager) Start(wg *sync.WaitGroup, quit <-chan struct{}) {
+	wg.Add(2)
+	go m.ipQueue.Run(wg, quit)
+	go m.runReports(wg, quit, m.reportReset)
+
+	for _, job := range m.jobs {
+		wg.Add(1)
+		go m.loop(wg, quit, job)
+	}
+}
+
+// Settings возвращает копию текущих настроек, безопасную для чтения в воркерах.
+func (m *SchedulerManager) Settings() *Settings {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s := *m.settings
+	return &s
+}
+
+// UpdateSettings применяет новые настройки, пересобирает каналы уведомлений
+// и будит циклы задач.
+func (m *SchedulerManager) UpdateSettings(s Settings) {
+	m.mu.Lock()
+	*m.settings = s
+	m.mu.Unlock()
+
+	m.notifier.Configure(&s)
+	m.ipQueue.Wake()
+	select {
+	case m.reportReset <- struct{}{}:
+	default:
+	}
+	for _, job := range m.jobs {
+		select {
+		case job.reset <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RunNow запускает задачу вне расписания, если она сейчас не выполняется.
+func (m *SchedulerManager) RunNow(name string) bool {
+	if name == JobIPCheck {
+		m.ipQueue.RunNow()
+		return true
+	}
+	for _, job := range m.jobs {
+		if job.name == name {
+			return m.trigger(job)
+		}
+	}
+	log.Printf("Scheduler: unknown job %q", name)
+	return false
+}
+
+// Reschedule перечитывает интервалы прокси и тегов после их изменения через API.
+func (m *SchedulerManager) Reschedule() {
+	m.ipQueue.Wake()
+}
+
+func (m *SchedulerManager) loop(wg *sync.WaitGroup, quit <-chan struct{}, job *schedulerJob) {
+	defer wg.Done()
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	var current time.Duration
+
+	apply := func() {
+		interval := job.interval(m.Settings())
+		if interval == current && (ticker != nil || interval <= 0) {
+			return
+		}
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		current = interval
+		if interval <= 0 {
+			log.Printf("Scheduler: %s is disabled because its interval is zero or negative.", job.name)
+			return
+		}
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+		log.Printf("Scheduler: %s scheduled every %v.", job.name, interval)
+	}
+
+	apply()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-tick:
+			m.trigger(job)
+		case <-job.reset:
+			apply()
+		case <-quit:
+			log.Printf("Scheduler: Shutting down %s scheduler.", job.name)
+			return
+		}
+	}
+}
+
+// trigger запускает задачу в отдельной горутине со снимком настроек.
+// Цикл ограничен по времени текущим интервалом задачи.
+func (m *SchedulerManager) trigger(job *schedulerJob) bool {
+	if !job.running.TryLock() {
+		log.Printf("Scheduler: %s skipped — previous job still running", job.name)
+		return false
+	}
+
+	stg := m.Settings()
+	go func() {
+		defer job.running.Unlock()
+
+		ctx := context.Background()
+		if interval := job.interval(stg); interval > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, interval)
+			defer cancel()
+		}
+		job.run(ctx, stg)
+	}()
+	return true
+}
+
+func (m *SchedulerManager) runHealthCheck(ctx context.Context, stg *Settings) {
+	log.Println("Scheduler: Starting scheduled health check for all proxies...")
+
+	// Без связи у чекера все замеры упадут - пропускаем цикл целиком
+	if !m.guard.Check(stg) {
+		log.Println("Scheduler: Health check skipped - checker is degraded (no connectivity).")
+		return
+	}
+
+	var proxies []Proxy
+	if err := m.db.Find(&proxies).Error; err != nil {
+		log.Println("Scheduler: Error fetching proxies for health check:", err)
+		return
+	}
+
+	// Мертвые прокси в backoff не тестируем на скорость: замер все равно
+	// упадет и только добавит строку в ProxyFailureLog. Их проверяет очередь IP.
+	alive := proxies[:0]
+	for _, p := range proxies {
+		if !inBackoff(&p, stg) {
+			alive = append(alive, p)
+		}
+	}
+	if skipped := len(proxies) - len(alive); skipped > 0 {
+		log.Printf("Scheduler: Skipping speed check for %d dead proxies in backoff.", skipped)
+	}
+
+	workers := m.healthWorkers.next(stg, JobHealthCheck, m.healthLoad)
+	start := time.Now()
+	checked, skipped := HealthCheckIteratorWithNotifications(ctx, alive, stg, workers, m.db, m.alerts, m.guard)
+	duration := time.Since(start)
+
+	// Пропущенные прокси означают, что цикл не уложился в интервал
+	m.healthLoad = 1
+	if interval := time.Duration(stg.SpeedCheckInterval) * time.Minute; skipped == 0 && interval > 0 {
+		m.healthLoad = float64(duration) / float64(interval)
+	}
+	m.stats.record(JobStats{
+		Job:          JobHealthCheck,
+		Workers:      workers,
+		LastStart:    start,
+		LastDuration: duration,
+		Checked:      checked,
+		Skipped:      skipped,
+	})
+
+	log.Printf("Scheduler: Finished scheduled health check in %v (%d checked, %d skipped, %d workers).", duration.Round(time.Second), checked, skipped, workers)
+}
+
+// CheckerStatus возвращает состояние связи самого чекера.
+func (m *SchedulerManager) CheckerStatus() CheckerStatus {
+	return m.guard.Status()
+}
+
+// Status возвращает статистику задач планировщика.
+func (m *SchedulerManager) Status() []JobStats {
+	return []JobStats{m.ipQueue.Stats(), m.stats.get(JobHealthCheck)}
+}
@@ -4,61 +4,29 @@ import (
 	"context"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// IPCheckIteratorWithNotifications checks proxies with notification support
-func IPCheckIteratorWithNotifications(ctx context.Context, proxies []Proxy, settings *Settings, db *gorm.DB, geoIPClient *GeoIPClient, notifier *NotificationService) {
-	var wg sync.WaitGroup
-	proxyChan := make(chan *Proxy, len(proxies))
-
-	// Start worker goroutines
-	for w := 0; w < MaxConcurrentWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for p := range proxyChan {
-				select {
-				case <-ctx.Done():
-					log.Println("Scheduler: IP check cancelled - context done")
-					return
-				default:
-					checkSingleProxyIPWithNotifications(p, settings, db, geoIPClient, notifier)
-				}
-			}
-		}()
-	}
-
-	// Send proxies to workers
-	for i := range proxies {
-		select {
-		case <-ctx.Done():
-			log.Println("Scheduler: Stopping IP check - context cancelled")
-			close(proxyChan)
-			wg.Wait()
-			return
-		case proxyChan <- &proxies[i]:
-		}
-	}
-	close(proxyChan)
-
-	wg.Wait()
-}
-
 // checkSingleProxyIPWithNotifications checks a single proxy with notifications
-func checkSingleProxyIPWithNotifications(p *Proxy, settings *Settings, db *gorm.DB, geoIPClient *GeoIPClient, notifier *NotificationService) {
+func checkSingleProxyIPWithNotifications(p *Proxy, settings *Settings, db *gorm.DB, geoIPClient *GeoIPClient, alerts *AlertManager, guard *ConnectivityGuard) {
 	log.Printf("Scheduler: Checking IP for proxy %s (%s)", p.Ip, p.Id)
 
 	lastCheck := p.LastCheck
-	wasDown := p.LastStatus == 2 // Remember if proxy was down before
 
 	// 1. Check Ping first
 	latency, err := Ping(settings, p)
 	if err != nil {
 		log.Printf("Scheduler: Ping failed for proxy %s: %v", p.Ip, err)
+
+		// Связь пропала у самого чекера - ошибку прокси не засчитываем
+		if !guard.Confirm(settings) {
+			log.Printf("Scheduler: Ignoring failure of proxy %s - checker is degraded", p.Ip)
+			return
+		}
 		p.Failures++
 		p.LastLatency = 0
 
@@ -75,12 +43,14 @@ func checkSingleProxyIPWithNotifications(p *Proxy, settings *Settings, db *gorm.
 			log.Printf("Failed to save failure log: %v", err)
 		}
 
-		if p.Failures > 2 {
+		if p.Failures >= deadFailureThreshold {
 			p.LastStatus = 2 // Mark as dead
 
-			// Notify if proxy went down
-			if !wasDown && settings.NotifyOnDown {
-				notifier.NotifyProxyDown(p, err.Error())
+			// Alert manager decides whether this is a new, repeated or flapping alert
+			alerts.ProxyDown(p, err.Error(), settings)
+
+			if err := TrackIncident(db, p); err != nil {
+				log.Printf("Scheduler: Failed to update incident for proxy %s: %v", p.Ip, err)
 			}
 		}
 	} else {
@@ -96,55 +66,56 @@ func checkSingleProxyIPWithNotifications(p *Proxy, settings *Settings, db *gorm.
 		}
 		p.LastCheck = time.Now()
 
-		// Notify if proxy recovered
-		if wasDown && settings.NotifyOnRecovery {
-			notifier.NotifyProxyRecovered(p)
+		// Resolves the down alert and notifies if it was reported
+		alerts.ProxyUp(p, settings)
+
+		if err := CloseIncident(db, p); err != nil {
+			log.Printf("Scheduler: Failed to close incident for proxy %s: %v", p.Ip, err)
 		}
 
 		// Now get real IP (only if proxy is working)
-		realIP, realCountry, operator, err := RealIp(settings, p, db, geoIPClient)
+		exitInfo, err := RealIp(settings, p, db, geoIPClient)
 		if err != nil {
 			log.Printf("Scheduler: Failed to get real IP for proxy %s: %v", p.Ip, err)
 
-			// Log IP check failure
-			failureLog := &ProxyFailureLog{
-				ID:        uuid.NewString(),
-				ProxyID:   p.Id,
-				Timestamp: time.Now(),
-				ErrorType: "ip_check_failed",
-				ErrorMsg:  err.Error(),
-				Latency:   latency,
-			}
-			if err := failureLog.Save(db); err != nil {
-				log.Printf("Failed to save failure log: %v", err)
+			// Log IP check failure unless the checker itself lost connectivity
+			if !guard.Confirm(settings) {
+				log.Printf("Scheduler: Not logging IP check failure of proxy %s - checker is degraded", p.Ip)
+			} else {
+				failureLog := &ProxyFailureLog{
+					ID:        uuid.NewString(),
+					ProxyID:   p.Id,
+					Timestamp: time.Now(),
+					ErrorType: "ip_check_failed",
+					ErrorMsg:  err.Error(),
+					Latency:   latency,
+				}
+				if err := failureLog.Save(db); err != nil {
+					log.Printf("Failed to save failure log: %v", err)
+				}
 			}
 		} else {
 			// Check if IP changed
 			oldIP := p.RealIP
+			realIP := exitInfo.Ip
+			p.applyExitInfo(exitInfo)
 			if oldIP != "" && oldIP != realIP && settings.NotifyOnIPChange {
-				notifier.NotifyIPChanged(p, oldIP, realIP)
+				alerts.notifier.NotifyIPChanged(p, oldIP, realIP)
 			}
 
-			p.RealIP = realIP
-			p.RealCountry = realCountry
-			p.Operator = operator
-
-			// Check if IP is stuck (>24 hours)
-			if p.Stack && settings.NotifyOnIPStuck {
-				// Calculate hours stuck
+			// Check if IP is stuck (>24 hours); the alert is sent once per stuck period
+			hours := 0
+			if p.Stack {
 				var lastLog ProxyIPLog
 				err := db.Where("proxy_id = ?", p.Id).
 					Order("timestamp desc").
 					Limit(1).
 					First(&lastLog).Error
-
 				if err == nil {
-					hours := int(time.Since(lastLog.Timestamp).Hours())
-					if hours >= 24 {
-						notifier.NotifyIPStuck(p, realIP, hours)
-					}
+					hours = int(time.Since(lastLog.Timestamp).Hours())
 				}
 			}
+			alerts.IPStuck(p, realIP, hours, settings)
 		}
 	}
 
@@ -154,63 +125,75 @@ func checkSingleProxyIPWithNotifications(p *Proxy, settings *Settings, db *gorm.
 	}
 }
 
-// HealthCheckIteratorWithNotifications checks proxy speeds with notifications
-func HealthCheckIteratorWithNotifications(ctx context.Context, proxies []Proxy, settings *Settings, db *gorm.DB, notifier *NotificationService) {
+// HealthCheckIteratorWithNotifications checks proxy speeds with notifications.
+// Returns how many proxies were checked and how many were skipped because ctx
+// was cancelled before their turn.
+func HealthCheckIteratorWithNotifications(ctx context.Context, proxies []Proxy, settings *Settings, workers int, db *gorm.DB, alerts *AlertManager, guard *ConnectivityGuard) (checked, skipped int) {
 	var wg sync.WaitGroup
+	var done atomic.Int64
 	proxyChan := make(chan *Proxy, len(proxies))
 
 	// Start worker goroutines
-	for w := 0; w < MaxConcurrentWorkers; w++ {
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for p := range proxyChan {
 				select {
 				case <-ctx.Done():
-					log.Println("Scheduler: Health check cancelled - context done")
 					return
 				default:
-					checkSingleProxyHealthWithNotifications(p, settings, db, notifier)
+					checkSingleProxyHealthWithNotifications(p, settings, db, alerts, guard)
+					done.Add(1)
 				}
 			}
 		}()
 	}
 
 	// Send proxies to workers
+send:
 	for i := range proxies {
 		select {
 		case <-ctx.Done():
-			log.Println("Scheduler: Stopping health check - context cancelled")
-			close(proxyChan)
-			wg.Wait()
-			return
+			break send
 		case proxyChan <- &proxies[i]:
 		}
 	}
 	close(proxyChan)
 
 	wg.Wait()
+
+	checked = int(done.Load())
+	skipped = len(proxies) - checked
+	if skipped > 0 {
+		log.Printf("Scheduler: Health check cancelled - %d of %d proxies skipped", skipped, len(proxies))
+	}
+	return checked, skipped
 }
 
 // checkSingleProxyHealthWithNotifications checks speed with notifications
-func checkSingleProxyHealthWithNotifications(p *Proxy, settings *Settings, db *gorm.DB, notifier *NotificationService) {
+func checkSingleProxyHealthWithNotifications(p *Proxy, settings *Settings, db *gorm.DB, alerts *AlertManager, guard *ConnectivityGuard) {
 	log.Printf("Scheduler: Health checking proxy %s (%s)-%s", p.Ip, p.Id, p.Name)
 
 	speed, upload, err := CheckSpeed(settings, p, db)
 	if err != nil {
 		log.Printf("Scheduler: Speed check failed for proxy %s-%s: %v", p.Name, p.Ip, err)
 
-		// Log speed check failure
-		failureLog := &ProxyFailureLog{
-			ID:        uuid.NewString(),
-			ProxyID:   p.Id,
-			Timestamp: time.Now(),
-			ErrorType: "speed_check_failed",
-			ErrorMsg:  err.Error(),
-			Latency:   p.LastLatency,
-		}
-		if err := failureLog.Save(db); err != nil {
-			log.Printf("Failed to save failure log: %v", err)
+		// Log speed check failure unless the checker itself lost connectivity
+		if !guard.Confirm(settings) {
+			log.Printf("Scheduler: Not logging speed check failure of proxy %s - checker is degraded", p.Ip)
+		} else {
+			failureLog := &ProxyFailureLog{
+				ID:        uuid.NewString(),
+				ProxyID:   p.Id,
+				Timestamp: time.Now(),
+				ErrorType: "speed_check_failed",
+				ErrorMsg:  err.Error(),
+				Latency:   p.LastLatency,
+			}
+			if err := failureLog.Save(db); err != nil {
+				log.Printf("Failed to save failure log: %v", err)
+			}
 		}
 	} else {
 		// Store speed in Mbps
@@ -218,9 +201,7 @@ func checkSingleProxyHealthWithNotifications(p *Proxy, settings *Settings, db *g
 		p.Upload = int(upload)
 
 		// Check if speed is below threshold
-		if settings.NotifyOnLowSpeed && p.Speed < settings.LowSpeedThreshold && p.Speed > 0 {
-			notifier.NotifyLowSpeed(p, settings.LowSpeedThreshold)
-		}
+		alerts.LowSpeed(p, settings)
 
 		log.Printf("Scheduler: Speed check completed for proxy %s - Download: %d Mbps, Upload: %d Mbps", p.Ip, p.Speed, p.Upload)
 	}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Мастер-ключ шифрования секретов в базе: base64 от 32 байт (AES-256).
+// Переменная окружения может содержать несколько ключей через запятую,
+// файл - по ключу на строку. Первый ключ шифрует, остальные - только для
+// чтения значений, зашифрованных до смены ключа.
+//
+// Файл по умолчанию лежит в каталоге настроек пользователя, а не рядом с
+// базой: копия каталога database/ не должна содержать ключ к своим секретам.
+const (
+	envMasterKey     = "PROXYCHECKER_MASTER_KEY"
+	envMasterKeyFile = "PROXYCHECKER_MASTER_KEY_FILE"
+	legacyKeyFile    = "database/master.key" // расположение в прошлых версиях
+)
+
+// Формат зашифрованного значения: enc:v1:<id ключа>:<base64(nonce|шифртекст)>
+const encryptedPrefix = "enc:v1:"
+
+// secretMask подставляется в ответы API вместо секретов. Если клиент
+// присылает маску обратно, сохраненное значение не меняется.
+const secretMask = "********"
+
+var ErrNoMasterKey = errors.New("master key is not loaded")
+
+type secretKey struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+}
+
+// secretKeyring - загруженные мастер-ключи.
+type secretKeyring struct {
+	primary *secretKey
+	keys    map[string]*secretKey
+	source  string // env или путь к файлу ключей
+}
+
+// secrets - ключи, которыми пользуется сериализатор encrypted.
+var secrets *secretKeyring
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+func newSecretKey(raw []byte) (*secretKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &secretKey{id: hex.EncodeToString(sum[:4]), raw: raw, aead: aead}, nil
+}
+
+func generateSecretKey() (*secretKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return newSecretKey(raw)
+}
+
+func newSecretKeyring(keys []*secretKey, source string) *secretKeyring {
+	r := &secretKeyring{primary: keys[0], keys: make(map[string]*secretKey), source: source}
+	for _, k := range keys {
+		r.keys[k.id] = k
+	}
+	return r
+}
+
+// parseSecretKeys разбирает ключи в base64, разделенные запятыми или переводами строк.
+func parseSecretKeys(value string) ([]*secretKey, error) {
+	var keys []*secretKey
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+		k, err := newSecretKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no master key found")
+	}
+	return keys, nil
+}
+
+// masterKeyFile возвращает путь к файлу ключей: PROXYCHECKER_MASTER_KEY_FILE
+// или <каталог настроек пользователя>/proxychecker/master.key.
+func masterKeyFile() (string, error) {
+	if path := os.Getenv(envMasterKeyFile); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no location for the master key file (%v), set %s or %s", err, envMasterKey, envMasterKeyFile)
+	}
+	path := filepath.Join(dir, "proxychecker", "master.key")
+
+	// Ключ, созданный прошлой версией в database/, читаем, пока его не перенесут
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(legacyKeyFile); err == nil {
+			log.Printf("Secrets: WARNING master key is stored next to the database in %s, move it to %s or set %s", legacyKeyFile, path, envMasterKeyFile)
+			return legacyKeyFile, nil
+		}
+	}
+	return path, nil
+}
+
+// LoadSecrets загружает мастер-ключи из PROXYCHECKER_MASTER_KEY или из файла
+// ключей. Если нет ни того, ни другого, создает файл с новым ключом - но
+// только для базы без зашифрованных значений: иначе ключ потерян (например,
+// контейнер пересоздан без тома с ключом), и новый ключ их не прочитает.
+func LoadSecrets(db *gorm.DB) error {
+	if value := os.Getenv(envMasterKey); value != "" {
+		keys, err := parseSecretKeys(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envMasterKey, err)
+		}
+		secrets = newSecretKeyring(keys, "env")
+		return nil
+	}
+
+	path, err := masterKeyFile()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		encrypted, err := hasEncryptedSecrets(db)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return fmt.Errorf("master key file %s not found, but the database already holds encrypted secrets: set %s or %s to the key they were encrypted with", path, envMasterKey, envMasterKeyFile)
+		}
+		k, err := generateSecretKey()
+		if err != nil {
+			return err
+		}
+		if err := writeKeyFile(path, []*secretKey{k}); err != nil {
+			return err
+		}
+		log.Printf("Secrets: generated new master key in %s - back it up and keep it out of database backups, without it the stored secrets cannot be decrypted", path)
+		secrets = newSecretKeyring([]*secretKey{k}, path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := parseSecretKeys(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	secrets = newSecretKeyring(keys, path)
+	return nil
+}
+
+// hasEncryptedSecrets проверяет, есть ли в базе значения, зашифрованные мастер-ключом.
+func hasEncryptedSecrets(db *gorm.DB) (bool, error) {
+	var proxies int64
+	if err := db.Model(&Proxy{}).Where("password LIKE ?", encryptedPrefix+"%").Count(&proxies).Error; err != nil {
+		return false, err
+	}
+	conds := make([]string, 0, len(settingsSecretColumns))
+	args := make([]any, 0, len(settingsSecretColumns))
+	for _, column := range settingsSecretColumns {
+		conds = append(conds, column+" LIKE ?")
+		args = append(args, encryptedPrefix+"%")
+	}
+	var settings int64
+	if err := db.Model(&Settings{}).Where(strings.Join(conds, " OR "), args...).Count(&settings).Error; err != nil {
+		return false, err
+	}
+	return proxies+settings > 0, nil
+}
+
+// writeKeyFile атомарно записывает ключи в файл с правами 0600.
+func writeKeyFile(path string, keys []*secretKey) error {
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(base64.StdEncoding.EncodeToString(k.raw))
+		b.WriteString("\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (r *secretKeyring) encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, r.primary.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := r.primary.aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + r.primary.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (r *secretKeyring) decrypt(value string) ([]byte, error) {
+	id, payload, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return nil, errors.New("malformed encrypted value")
+	}
+	k := r.keys[id]
+	if k == nil {
+		return nil, fmt.Errorf("value is encrypted with unknown master key %s", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	size := k.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("malformed encrypted value")
+	}
+	return k.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
+
+// EncryptedSerializer шифрует поле при записи в базу (gorm:"serializer:encrypted").
+// Строки шифруются как есть, остальные типы - в виде JSON. Пустая строка
+// не шифруется. Незашифрованные значения из старых версий читаются как есть
+// и шифруются при следующем сохранении.
+type EncryptedSerializer struct{}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var data []byte
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported encrypted value type %T", dbValue)
+	}
+
+	if strings.HasPrefix(string(data), encryptedPrefix) {
+		if secrets == nil {
+			return ErrNoMasterKey
+		}
+		plain, err := secrets.decrypt(string(data))
+		if err != nil {
+			return fmt.Errorf("decrypt %s: %w", field.Name, err)
+		}
+		data = plain
+	}
+
+	fieldValue := reflect.New(field.FieldType)
+	if field.FieldType.Kind() == reflect.String {
+		fieldValue.Elem().SetString(string(data))
+	} else if len(data) > 0 {
+		if err := json.Unmarshal(data, fieldValue.Interface()); err != nil {
+			return err
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var data []byte
+	if s, ok := fieldValue.(string); ok {
+		if s == "" {
+			return "", nil
+		}
+		data = []byte(s)
+	} else {
+		var err error
+		if data, err = json.Marshal(fieldValue); err != nil {
+			return nil, err
+		}
+		if string(data) == "null" {
+			return nil, nil
+		}
+	}
+	if secrets == nil {
+		return nil, ErrNoMasterKey
+	}
+	return secrets.encrypt(data)
+}
+
+// EncryptLegacySecrets шифрует секреты, сохраненные версиями без шифрования.
+func EncryptLegacySecrets(db *gorm.DB) error {
+	var ids []string
+	if err := db.Model(&Proxy{}).
+		Where("password <> '' AND password NOT LIKE ?", encryptedPrefix+"%").
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	var plainSettings int64
+	conds := make([]string, 0, len(settingsSecretColumns))
+	args := make([]any, 0, len(settingsSecretColumns))
+	for _, column := range settingsSecretColumns {
+		conds = append(conds, fmt.Sprintf("(%s <> '' AND %s NOT LIKE ?)", column, column))
+		args = append(args, encryptedPrefix+"%")
+	}
+	if err := db.Model(&Settings{}).Where(strings.Join(conds, " OR "), args...).Count(&plainSettings).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 && plainSettings == 0 {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(ids) > 0 {
+			if err := resaveProxies(tx, tx.Where("id IN ?", ids)); err != nil {
+				return err
+			}
+		}
+		if plainSettings > 0 {
+			return resaveSettings(tx)
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Secrets: encrypted %d proxy passwords and %d settings rows stored in plaintext", len(ids), plainSettings)
+	}
+	return err
+}
+
+// Колонки настроек, которые хранятся зашифрованными
+var settingsSecretColumns = []string{"telegram_token", "webhooks", "slack_webhook_url", "discord_webhook_url", "smtp_password"}
+
+// RotateMasterKey создает новый мастер-ключ и перешифровывает им все секреты.
+// Старые ключи остаются в файле (или в выведенном значении переменной
+// окружения) до PruneMasterKeys: копии базы и процессы, еще не получившие
+// новый ключ, продолжают читать секреты. Сервер на время смены ключа должен
+// быть остановлен - main проверяет это по ServerLease. Для ключа из
+// окружения новое значение PROXYCHECKER_MASTER_KEY пишется в out, а не в лог.
+func RotateMasterKey(db *gorm.DB, out io.Writer) error {
+	if secrets == nil {
+		return ErrNoMasterKey
+	}
+	next, err := generateSecretKey()
+	if err != nil {
+		return err
+	}
+	keys := append([]*secretKey{next}, secrets.ordered()...)
+
+	// Сначала сохраняем новый ключ рядом со старыми: если перешифровка
+	// прервется, база все равно останется читаемой
+	fromEnv := secrets.source == "env"
+	if !fromEnv {
+		if err := writeKeyFile(secrets.source, keys); err != nil {
+			return err
+		}
+	}
+	secrets = newSecretKeyring(keys, secrets.source)
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := resaveProxies(tx, tx); err != nil {
+			return err
+		}
+		return resaveSettings(tx)
+	}); err != nil {
+		return err
+	}
+
+	if fromEnv {
+		log.Printf("Secrets: re-encrypted with new master key %s, set the printed %s before the next start", next.id, envMasterKey)
+		fmt.Fprintf(out, "%s=%s\n", envMasterKey, encodeSecretKeys(keys))
+		return nil
+	}
+	log.Printf("Secrets: re-encrypted with new master key %s, saved to %s with %d previous keys; run -prune-keys once every copy uses it", next.id, secrets.source, len(keys)-1)
+	return nil
+}
+
+// PruneMasterKeys удаляет из файла ключей все ключи, кроме текущего, если
+// ни одно значение в базе ими больше не зашифровано. Для ключа из окружения
+// новое значение PROXYCHECKER_MASTER_KEY пишется в out.
+func PruneMasterKeys(db *gorm.DB, out io.Writer) error {
+	if secrets == nil {
+		return ErrNoMasterKey
+	}
+	if len(secrets.keys) == 1 {
+		log.Printf("Secrets: only the current master key %s is loaded, nothing to prune", secrets.primary.id)
+		return nil
+	}
+
+	current := encryptedPrefix + secrets.primary.id + ":%"
+	stale := func(model any, columns []string) (int64, error) {
+		conds := make([]string, 0, len(columns))
+		args := make([]any, 0, 2*len(columns))
+		for _, column := range columns {
+			conds = append(conds, fmt.Sprintf("(%s LIKE ? AND %s NOT LIKE ?)", column, column))
+			args = append(args, encryptedPrefix+"%", current)
+		}
+		var count int64
+		err := db.Model(model).Where(strings.Join(conds, " OR "), args...).Count(&count).Error
+		return count, err
+	}
+	proxies, err := stale(&Proxy{}, []string{"password"})
+	if err != nil {
+		return err
+	}
+	settings, err := stale(&Settings{}, settingsSecretColumns)
+	if err != nil {
+		return err
+	}
+	if proxies+settings > 0 {
+		return fmt.Errorf("%d proxies and %d settings rows are still encrypted with previous master keys, run -rotate-key first", proxies, settings)
+	}
+
+	keys := []*secretKey{secrets.primary}
+	if secrets.source == "env" {
+		fmt.Fprintf(out, "%s=%s\n", envMasterKey, encodeSecretKeys(keys))
+	} else if err := writeKeyFile(secrets.source, keys); err != nil {
+		return err
+	}
+	log.Printf("Secrets: pruned %d previous master keys, keeping %s", len(secrets.keys)-1, secrets.primary.id)
+	secrets = newSecretKeyring(keys, secrets.source)
+	return nil
+}
+
+// ordered возвращает ключи связки, текущий первым.
+func (r *secretKeyring) ordered() []*secretKey {
+	keys := make([]*secretKey, 0, len(r.keys))
+	keys = append(keys, r.primary)
+	for _, k := range r.keys {
+		if k != r.primary {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// encodeSecretKeys возвращает ключи в формате PROXYCHECKER_MASTER_KEY.
+func encodeSecretKeys(keys []*secretKey) string {
+	encoded := make([]string, len(keys))
+	for i, k := range keys {
+		encoded[i] = base64.StdEncoding.EncodeToString(k.raw)
+	}
+	return strings.Join(encoded, ",")
+}
+
+// resaveProxies перезаписывает прокси из query, шифруя их текущим ключом.
+func resaveProxies(tx, query *gorm.DB) error {
+	var proxies []Proxy
+	if err := query.Find(&proxies).Error; err != nil {
+		return err
+	}
+	for i := range proxies {
+		if err := tx.Model(&proxies[i]).Select("password").Updates(&proxies[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resaveSettings(tx *gorm.DB) error {
+	var rows []Settings
+	if err := tx.Where("id = ?", 1).Limit(1).Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[0].Save(tx)
+}
+
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return secretMask
+}
+
+// keepSecret возвращает current, если клиент прислал маску вместо значения.
+func keepSecret(value, current string) string {
+	if value == secretMask {
+		return current
+	}
+	return value
+}
+
+// Masked возвращает копию прокси со скрытым паролем.
+func (s Proxy) Masked() Proxy {
+	s.Password = maskSecret(s.Password)
+	return s
+}
+
+func maskProxies(list []Proxy) []Proxy {
+	masked := make([]Proxy, len(list))
+	for i, p := range list {
+		masked[i] = p.Masked()
+	}
+	return masked
+}
+
+// Masked возвращает копию настроек со скрытыми токенами и паролями каналов.
+func (s Settings) Masked() Settings {
+	s.TelegramToken = maskSecret(s.TelegramToken)
+	s.SlackWebhookURL = maskSecret(s.SlackWebhookURL)
+	s.DiscordWebhookURL = maskSecret(s.DiscordWebhookURL)
+	s.SMTPPassword = maskSecret(s.SMTPPassword)
+	webhooks := make([]WebhookTarget, len(s.Webhooks))
+	for i, w := range s.Webhooks {
+		w.Secret = maskSecret(w.Secret)
+		webhooks[i] = w
+	}
+	s.Webhooks = webhooks
+	return s
+}
+
+// keepSecrets подставляет сохраненные секреты вместо масок, присланных клиентом.
+// Секрет вебхука берется у вебхука с тем же URL.
+func (s *Settings) keepSecrets(current *Settings) {
+	s.TelegramToken = keepSecret(s.TelegramToken, current.TelegramToken)
+	s.SlackWebhookURL = keepSecret(s.SlackWebhookURL, current.SlackWebhookURL)
+	s.DiscordWebhookURL = keepSecret(s.DiscordWebhookURL, current.DiscordWebhookURL)
+	s.SMTPPassword = keepSecret(s.SMTPPassword, current.SMTPPassword)
+	for i := range s.Webhooks {
+		if s.Webhooks[i].Secret != secretMask {
+			continue
+		}
+		s.Webhooks[i].Secret = ""
+		for _, w := range current.Webhooks {
+			if w.URL == s.Webhooks[i].URL {
+				s.Webhooks[i].Secret = w.Secret
+				break
+			}
+		}
+	}
+}
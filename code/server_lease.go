@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Работающий сервер раз в serverLeaseInterval отмечается в базе. Служебные
+// команды (-rotate-key, -prune-keys) по этой отметке отказываются работать,
+// пока сервер запущен: он держит в памяти старые ключи и перезапишет
+// секреты ими. Отметка старше serverLeaseTimeout считается брошенной
+// (сервер упал, не сняв ее).
+const (
+	serverLeaseInterval = 30 * time.Second
+	serverLeaseTimeout  = 3 * serverLeaseInterval
+)
+
+// ServerLease - отметка работающего сервера. Запись одна, ID=1.
+type ServerLease struct {
+	ID          uint      `gorm:"primaryKey"`
+	Host        string    `json:"host"`
+	PID         int       `json:"pid"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+func (ServerLease) TableName() string {
+	return "server_lease"
+}
+
+// ActiveServerLease возвращает отметку работающего сервера или nil.
+func ActiveServerLease(db *gorm.DB) (*ServerLease, error) {
+	var leases []ServerLease
+	if err := db.Where("id = ? AND heartbeat_at > ?", 1, time.Now().Add(-serverLeaseTimeout)).
+		Limit(1).
+		Find(&leases).Error; err != nil {
+		return nil, err
+	}
+	if len(leases) == 0 {
+		return nil, nil
+	}
+	return &leases[0], nil
+}
+
+// HoldServerLease обновляет отметку сервера, пока не закрыт quit, и снимает ее
+// при остановке. wg.Add(1) делает вызывающий до запуска горутины.
+func HoldServerLease(wg *sync.WaitGroup, quit <-chan struct{}, db *gorm.DB) {
+	defer wg.Done()
+
+	host, _ := os.Hostname()
+	lease := ServerLease{ID: 1, Host: host, PID: os.Getpid()}
+	beat := func() {
+		lease.HeartbeatAt = time.Now()
+		if err := db.Save(&lease).Error; err != nil {
+			log.Printf("Server lease: failed to update heartbeat: %v", err)
+		}
+	}
+	beat()
+
+	ticker := time.NewTicker(serverLeaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			beat()
+		case <-quit:
+			if err := db.Delete(&ServerLease{}, 1).Error; err != nil {
+				log.Printf("Server lease: failed to release: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -10,26 +13,138 @@ type Settings struct {
 	Timeout            int    `json:"timeout"`
 	CheckIPInterval    int    `json:"checkIPInterval"`
 	SpeedCheckInterval int    `json:"speedCheckInterval"`
-	Username           string `json:"username"`
-	Password           string `json:"password"`
-	SkipSSLVerify      bool   `json:"skipSSLVerify"` // Allow configuring SSL verification
+	SkipSSLVerify      bool   `json:"skipSSLVerify"`      // Allow configuring SSL verification
+	Workers            int    `json:"workers"`            // Concurrent check workers (0 = default)
+	IPWorkers          int    `json:"ipWorkers"`          // IP check workers (0 = Workers)
+	HealthWorkers      int    `json:"healthWorkers"`      // Health check workers (0 = Workers)
+	SpeedWorkers       int    `json:"speedWorkers"`       // Concurrent speed tests (0 = Workers)
+	AdaptiveWorkers    bool   `json:"adaptiveWorkers"`    // Grow/shrink workers from cycle duration vs interval
+	MaxWorkers         int    `json:"maxWorkers"`         // Upper bound for adaptive workers
+	CheckJitterPercent int    `json:"checkJitterPercent"` // Random ± spread of IP check intervals, percent
+
+	// Backoff for dead proxies: interval = base * multiplier^n, capped at max
+	BackoffEnabled    bool    `json:"backoffEnabled"`
+	BackoffBase       int     `json:"backoffBase"` // minutes (0 = regular check interval)
+	BackoffMax        int     `json:"backoffMax"`  // minutes
+	BackoffMultiplier float64 `json:"backoffMultiplier"`
+
+	// Probe settings
+	ProbeSet            string `json:"probeSet"`            // Comma-separated probes: tcp, http, https_connect
+	ProbeExpectedStatus int    `json:"probeExpectedStatus"` // Expected HTTP status for http probe (0 = any below 400)
+	ProbeExpectedBody   string `json:"probeExpectedBody"`   // Substring the http probe response must contain
+	ProbeHTTPSURL       string `json:"probeHttpsUrl"`       // https:// target of the https_connect probe (empty = Url if it is https)
+
+	// Self-hosted speed test (empty SpeedTestURL = speedtest.net)
+	SpeedTestURL       string `json:"speedTestUrl"`       // Download URL, e.g. http://host:8090/speedtest/download?size=10
+	SpeedTestUploadURL string `json:"speedTestUploadUrl"` // Upload sink, e.g. http://host:8090/speedtest/upload
+	SpeedTestSizeMB    int    `json:"speedTestSizeMB"`    // Upload payload size in megabytes
+
+	// Exit IP resolvers, tried in order (empty = built-in list)
+	IPResolvers []IPResolver `json:"ipResolvers" gorm:"serializer:json"`
+	IPConsensus bool         `json:"ipConsensus"` // Require two resolvers to return the same IP
+
+	// Checker self-check: direct probes before proxy checks
+	CanaryEnabled bool     `json:"canaryEnabled"`
+	CanaryTargets []string `json:"canaryTargets" gorm:"serializer:json"` // URLs or host:port (empty = defaults)
 
 	// Notification settings
 	TelegramEnabled      bool   `json:"telegramEnabled"`
-	TelegramToken        string `json:"telegramToken"`
+	TelegramToken        string `json:"telegramToken" gorm:"serializer:encrypted"`
 	TelegramChatID       string `json:"telegramChatID"`
+	TelegramEvents       []string `json:"telegramEvents" gorm:"serializer:json"` // Event filter (empty = all)
+	Webhooks             []WebhookTarget `json:"webhooks" gorm:"serializer:encrypted"` // Generic JSON webhooks (encrypted: contain signing secrets)
+
+	// Slack incoming webhook (Block Kit) and Discord webhook (embeds)
+	SlackEnabled      bool     `json:"slackEnabled"`
+	SlackWebhookURL   string   `json:"slackWebhookUrl" gorm:"serializer:encrypted"`
+	SlackEvents       []string `json:"slackEvents" gorm:"serializer:json"` // Event filter (empty = all)
+	DiscordEnabled    bool     `json:"discordEnabled"`
+	DiscordWebhookURL string   `json:"discordWebhookUrl" gorm:"serializer:encrypted"`
+	DiscordEvents     []string `json:"discordEvents" gorm:"serializer:json"` // Event filter (empty = all)
+
+	// Email over SMTP (security: none, starttls, tls)
+	EmailEnabled        bool     `json:"emailEnabled"`
+	SMTPHost            string   `json:"smtpHost"`
+	SMTPPort            int      `json:"smtpPort"`
+	SMTPSecurity        string   `json:"smtpSecurity"`
+	SMTPUsername        string   `json:"smtpUsername"`
+	SMTPPassword        string   `json:"smtpPassword" gorm:"serializer:encrypted"`
+	EmailFrom           string   `json:"emailFrom"`
+	EmailTo             []string `json:"emailTo" gorm:"serializer:json"`
+	EmailNotifyContacts bool     `json:"emailNotifyContacts"` // Also mail the proxy's Contacts address
+	EmailEvents         []string `json:"emailEvents" gorm:"serializer:json"` // Event filter (empty = all)
+	EmailDigestMinutes  int      `json:"emailDigestMinutes"`                 // Batch events into one email per N minutes (0 = immediately)
+
 	NotifyOnDown         bool   `json:"notifyOnDown"`         // Notify when proxy goes down
 	NotifyOnRecovery     bool   `json:"notifyOnRecovery"`     // Notify when proxy recovers
 	NotifyOnIPChange     bool   `json:"notifyOnIPChange"`     // Notify when IP changes
 	NotifyOnIPStuck      bool   `json:"notifyOnIPStuck"`      // Notify when IP is stuck >24h
 	NotifyOnLowSpeed     bool   `json:"notifyOnLowSpeed"`     // Notify when speed is low
 	LowSpeedThreshold    int    `json:"lowSpeedThreshold"`    // Mbps threshold for low speed
+	AlertRenotifyMinutes int    `json:"alertRenotifyMinutes"` // Repeat an open alert every N minutes (0 = never)
+	AlertMinDownMinutes  int    `json:"alertMinDownMinutes"`  // Proxy must stay down this long before the down alert
+	FlapDetection        bool   `json:"flapDetection"`        // Collapse rapid down/up changes into one flapping alert
+	FlapThreshold        int    `json:"flapThreshold"`        // State changes within the window that mean flapping
+	FlapWindowMinutes    int    `json:"flapWindowMinutes"`    // Flap detection window, extended to cover threshold down/up cycles at the check interval
+	OutageGrouping       bool   `json:"outageGrouping"`       // Send one message for proxies that go down together
+	OutageWindowSeconds  int    `json:"outageWindowSeconds"`  // Minimum time to collect down alerts before grouping, extended to the IP check interval
+	OutageMinProxies     int    `json:"outageMinProxies"`     // Smallest operator/tag/subnet group reported as an outage
+	OutageCheckerPercent int    `json:"outageCheckerPercent"` // Share of alive proxies down at once that means checker connectivity loss
+	NotifyOnSLABreach    bool   `json:"notifyOnSLABreach"`    // Notify when availability drops below the SLA target
+	SLAWindowDays        int    `json:"slaWindowDays"`        // Period the SLA target is checked over
 	NotifyDailySummary   bool   `json:"notifyDailySummary"`   // Send daily summary
 	DailySummaryTime     string `json:"dailySummaryTime"`     // Time for daily summary (HH:MM format)
+	NotifyWeeklySummary  bool   `json:"notifyWeeklySummary"`  // Send weekly summary at DailySummaryTime
+	WeeklySummaryDay     int    `json:"weeklySummaryDay"`     // Weekday for weekly summary (0 = Sunday)
+	ReportTimezone       string `json:"reportTimezone"`       // IANA timezone for summaries (empty = server local)
+
+	// Version of the stored defaults, see settingsMigrations
+	Version int `json:"-"`
+}
+
+// settingsMigrations заполняют значения по умолчанию у новых полей в
+// настройках, сохраненных до их появления: AutoMigrate оставляет в таких
+// колонках нули. Шаг i переводит настройки с версии i на i+1.
+var settingsMigrations = []func(s *Settings){
+	// Jitter проверок IP: 0 в старой записи - не "выключено", а "не задано"
+	func(s *Settings) {
+		if s.CheckJitterPercent == 0 {
+			s.CheckJitterPercent = defaultCheckJitterPercent
+		}
+	},
+	// Backoff мертвых прокси: у старых установок он выключен, а шаг и предел
+	// нулевые. Включаем, если параметры backoff ни разу не задавались.
+	func(s *Settings) {
+		if s.BackoffMax == 0 && s.BackoffMultiplier == 0 {
+			s.BackoffEnabled = true
+			s.BackoffBase = defaultBackoffBaseMinutes
+			s.BackoffMax = defaultBackoffMaxMinutes
+			s.BackoffMultiplier = defaultBackoffMultiplier
+		}
+	},
+	// Canary-проверки связи чекера. Пустой CanaryTargets - встроенные цели
+	// defaultCanaryTargets, поэтому достаточно включить проверку.
+	func(s *Settings) {
+		s.CanaryEnabled = true
+	},
+}
+
+// migrate применяет к сохраненным настройкам недостающие шаги settingsMigrations.
+func (s *Settings) migrate(db *gorm.DB) error {
+	if s.Version >= len(settingsMigrations) {
+		return nil
+	}
+	for _, step := range settingsMigrations[s.Version:] {
+		step(s)
+	}
+	log.Printf("Settings: applied defaults for settings version %d -> %d", s.Version, len(settingsMigrations))
+	return s.Save(db)
 }
 
 func (s *Settings) Save(db *gorm.DB) error {
 	s.ID = 1
+	// Сохраненные через API или по умолчанию настройки уже содержат все поля
+	s.Version = len(settingsMigrations)
 	return db.Save(s).Error
 }
 
@@ -43,12 +158,6 @@ func (s *Settings) Get(db *gorm.DB) (*Settings, error) {
 func SettingsDefault(db *gorm.DB) *Settings {
 	s := Settings{}
 	settings, err := s.Get(db)
-	if settings.Username == "" {
-		settings.Username = "default_username"
-		settings.Password = "default_password"
-		settings.Save(db)
-	}
-
 	if err == gorm.ErrRecordNotFound {
 		stg := &Settings{
 			ID:                 1,
@@ -56,9 +165,17 @@ func SettingsDefault(db *gorm.DB) *Settings {
 			Timeout:            5,
 			CheckIPInterval:    5,
 			SpeedCheckInterval: 15,
-			Username:           "default_username",
-			Password:           "default_password",
+			Workers:            MaxConcurrentWorkers,
+			MaxWorkers:         defaultMaxWorkers,
+			CheckJitterPercent: defaultCheckJitterPercent,
+			BackoffEnabled:     true,
+			BackoffBase:        defaultBackoffBaseMinutes,
+			BackoffMax:         defaultBackoffMaxMinutes,
+			BackoffMultiplier:  defaultBackoffMultiplier,
 			SkipSSLVerify:      true, // Default to true for backward compatibility
+			CanaryEnabled:      true,
+			ProbeSet:           defaultProbeSet,
+			SpeedTestSizeMB:    defaultSpeedTestSizeMB,
 			// Notification defaults
 			TelegramEnabled:    false,
 			NotifyOnDown:       true,
@@ -67,16 +184,26 @@ func SettingsDefault(db *gorm.DB) *Settings {
 			NotifyOnIPStuck:    true,
 			NotifyOnLowSpeed:   false,
 			LowSpeedThreshold:  10, // 10 Mbps
+			FlapDetection:      true,
+			FlapThreshold:      defaultFlapThreshold,
+			FlapWindowMinutes:  defaultFlapWindowMinutes,
+			OutageGrouping:     true,
+			NotifyOnSLABreach:  true,
+			SLAWindowDays:      defaultSLAWindowDays,
 			NotifyDailySummary: false,
 			DailySummaryTime:   "09:00",
+			WeeklySummaryDay:   int(time.Monday),
 		}
 		err := stg.Save(db)
 		if err != nil {
 			panic(err)
 		}
+		settings = stg
 
 	} else if err != nil {
 		panic(err)
+	} else if err := settings.migrate(db); err != nil {
+		panic(err)
 	}
 
 	return settings
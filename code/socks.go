@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks4Dialer устанавливает TCP-соединения через SOCKS4 прокси.
+// Стандартная библиотека поддерживает только SOCKS5, поэтому протокол
+// реализован здесь. Имена хостов разрешаются локально, если это не удаётся -
+// используется расширение SOCKS4a.
+type socks4Dialer struct {
+	proxyAddr string
+	userID    string
+	forward   *net.Dialer
+}
+
+const (
+	socks4Version        = 0x04
+	socks4CmdConnect     = 0x01
+	socks4RequestGranted = 0x5a
+)
+
+func (d *socks4Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" {
+		return nil, fmt.Errorf("socks4: network %s is not supported", network)
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("socks4: invalid port %q", portStr)
+	}
+
+	// Заголовок запроса: VN, CD, DSTPORT, DSTIP, USERID, NULL [, HOST, NULL]
+	req := []byte{socks4Version, socks4CmdConnect, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+
+	var ip4 net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ip4 = ip.To4()
+		if ip4 == nil {
+			return nil, errors.New("socks4: IPv6 targets are not supported")
+		}
+	} else if ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host); err == nil && len(ips) > 0 {
+		ip4 = ips[0].To4()
+	}
+
+	if ip4 != nil {
+		req = append(req, ip4...)
+		req = append(req, d.userID...)
+		req = append(req, 0)
+	} else {
+		// SOCKS4a: 0.0.0.x и имя хоста после USERID
+		req = append(req, 0, 0, 0, 1)
+		req = append(req, d.userID...)
+		req = append(req, 0)
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks4: failed to write request: %w", err)
+	}
+
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks4: failed to read response: %w", err)
+	}
+	if resp[1] != socks4RequestGranted {
+		conn.Close()
+		return nil, fmt.Errorf("socks4: request rejected with code 0x%02x", resp[1])
+	}
+
+	// Сбрасываем deadline - дальше соединением управляет http.Transport
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TagSchedule переопределяет интервал проверки IP и цель SLA для всех прокси
+// с тегом. Значения, заданные у самого прокси, имеют приоритет над тегом.
+type TagSchedule struct {
+	Tag           string  `json:"tag" gorm:"primaryKey"`
+	CheckInterval int     `json:"checkInterval"` // minutes
+	SLATarget     float64 `json:"slaTarget"`     // availability target, percent (0 = none)
+}
+
+// Save creates or updates a tag schedule
+func (t *TagSchedule) Save(db *gorm.DB) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tag"}},
+		UpdateAll: true,
+	}).Create(t).Error
+}
+
+func (t *TagSchedule) List(db *gorm.DB) ([]TagSchedule, error) {
+	schedules := []TagSchedule{}
+	err := db.Model(t).Order("tag").Find(&schedules).Error
+	return schedules, err
+}
+
+func (t *TagSchedule) Delete(db *gorm.DB) error {
+	return db.Where("tag = ?", t.Tag).Delete(&TagSchedule{}).Error
+}
+
+// tagIntervals возвращает интервалы тегов в виде map[tag]minutes.
+func tagIntervals(db *gorm.DB) (map[string]int, error) {
+	var t TagSchedule
+	schedules, err := t.List(db)
+	if err != nil {
+		return nil, err
+	}
+	intervals := make(map[string]int, len(schedules))
+	for _, s := range schedules {
+		intervals[s.Tag] = s.CheckInterval
+	}
+	return intervals, nil
+}
+
+// tagSLATargets возвращает цели SLA тегов в виде map[tag]percent.
+func tagSLATargets(db *gorm.DB) (map[string]float64, error) {
+	var t TagSchedule
+	schedules, err := t.List(db)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]float64, len(schedules))
+	for _, s := range schedules {
+		if s.SLATarget > 0 {
+			targets[s.Tag] = s.SLATarget
+		}
+	}
+	return targets, nil
+}
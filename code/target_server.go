@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Размер данных для замера по умолчанию и верхняя граница для одного запроса
+	defaultSpeedTestSizeMB = 10
+	maxSpeedTestSizeMB     = 100
+	// Максимальное время одного замера скорости через собственный сервер
+	speedTestTimeout = 2 * time.Minute
+)
+
+// speedTargetChunk - блок случайных данных, чтобы сжатие на прокси не искажало замер.
+var speedTargetChunk = func() []byte {
+	b := make([]byte, 64*1024)
+	rand.Read(b)
+	return b
+}()
+
+// chunkReader бесконечно повторяет speedTargetChunk - тело upload-запроса,
+// которое, как и download, прокси не сможет сжать.
+type chunkReader struct{ off int }
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	n := copy(p, speedTargetChunk[r.off:])
+	r.off = (r.off + n) % len(speedTargetChunk)
+	return n, nil
+}
+
+// NewTargetServer создает HTTP-сервер с эндпоинтами, на которые другой экземпляр
+// чекера может направлять замеры через прокси:
+//
+//	GET  /speedtest/download?size=N - отдает N мегабайт данных
+//	POST /speedtest/upload          - принимает и отбрасывает тело запроса
+//	GET  /ip                        - возвращает IP, с которого пришел запрос
+//
+// Сервер работает на отдельном порту. Если задан token, запросы должны
+// передавать его в параметре ?token= (его можно вписать прямо в адреса
+// замера в настройках) или в заголовке "Authorization: Bearer".
+func NewTargetServer(addr, token string) *http.Server {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if token != "" {
+		router.Use(targetTokenRequired(token))
+	}
+
+	router.GET("/speedtest/download", speedTargetDownload)
+	router.POST("/speedtest/upload", speedTargetUpload)
+	router.GET("/ip", ipEchoTarget)
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+}
+
+// targetTokenRequired пропускает только запросы с общим токеном.
+func targetTokenRequired(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := c.Query("token")
+		if header := c.GetHeader("Authorization"); got == "" && strings.HasPrefix(header, "Bearer ") {
+			got = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid target token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func speedTargetDownload(c *gin.Context) {
+	sizeMB, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(defaultSpeedTestSizeMB)))
+	if err != nil || sizeMB < 0 || sizeMB > maxSpeedTestSizeMB {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between 0 and %d MB", maxSpeedTestSizeMB)})
+		return
+	}
+
+	remaining := int64(sizeMB) * 1024 * 1024
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", strconv.FormatInt(remaining, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	for remaining > 0 {
+		chunk := speedTargetChunk
+		if remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := c.Writer.Write(chunk)
+		if err != nil {
+			return
+		}
+		remaining -= int64(n)
+	}
+}
+
+func speedTargetUpload(c *gin.Context) {
+	start := time.Now()
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxSpeedTestSizeMB*1024*1024)
+	n, err := io.Copy(io.Discard, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("upload must not exceed %d MB", maxSpeedTestSizeMB)})
+		return
+	}
+	if err != nil {
+		log.Printf("Speed target: upload aborted after %d bytes: %v", n, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "upload aborted"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bytes":       n,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+// ipEchoTarget отдает адрес клиента в JSON и в заголовке X-Client-IP, поэтому
+// подходит для резолвера как с форматом json, так и header.
+// Заголовки X-Forwarded-For намеренно игнорируются: прокси может подставить
+// в них адрес чекера, а нужен именно выходной IP прокси.
+func ipEchoTarget(c *gin.Context) {
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+	c.Header("X-Client-IP", ip)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"ip": ip})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Роли пользователей: каждая следующая включает права предыдущей
+const (
+	RoleViewer   = "viewer"   // только чтение
+	RoleOperator = "operator" // управление прокси, импорт, проверки
+	RoleAdmin    = "admin"    // настройки и пользователи
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+const (
+	sessionTTL        = 7 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+// Переменные окружения для первого администратора
+const (
+	envAdminUser     = "PROXYCHECKER_ADMIN_USER"
+	envAdminPassword = "PROXYCHECKER_ADMIN_PASSWORD"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrLastAdmin          = errors.New("at least one admin must remain")
+)
+
+// User - учетная запись панели. Пароль хранится только в виде bcrypt-хеша.
+type User struct {
+	ID           string     `json:"id" gorm:"primaryKey"`
+	Username     string     `json:"username" gorm:"uniqueIndex"`
+	PasswordHash string     `json:"-"`
+	Role         string     `json:"role"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastLoginAt  *time.Time `json:"lastLoginAt"`
+}
+
+// Session - сессия входа. В базе лежит только SHA-256 токена из cookie,
+// поэтому утечка базы не дает войти чужими сессиями.
+type Session struct {
+	ID        string `gorm:"primaryKey"` // SHA-256 токена, hex
+	UserID    string `gorm:"index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (User) TableName() string {
+	return "users"
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+func validRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// HasRole проверяет, что роль пользователя не ниже role.
+func (u *User) HasRole(role string) bool {
+	return roleLevels[u.Role] >= roleLevels[role]
+}
+
+// SetPassword хеширует и сохраняет в структуре новый пароль.
+func (u *User) SetPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+func (u *User) Save(db *gorm.DB) error {
+	return db.Save(u).Error
+}
+
+func (u *User) Get(db *gorm.DB, id string) error {
+	return db.First(u, "id = ?", id).Error
+}
+
+// ListUsers возвращает всех пользователей по имени.
+func ListUsers(db *gorm.DB) ([]User, error) {
+	users := []User{}
+	err := db.Order("username").Find(&users).Error
+	return users, err
+}
+
+// Authenticate проверяет имя и пароль. Для неизвестного имени тоже
+// считается bcrypt, чтобы по времени ответа нельзя было подобрать имена.
+func Authenticate(db *gorm.DB, username, password string) (*User, error) {
+	var users []User
+	if err := db.Where("username = ?", username).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if !users[0].CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &users[0], nil
+}
+
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// DeleteUser удаляет пользователя и его сессии.
+func DeleteUser(db *gorm.DB, u *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureAdminRemains(tx, u, ""); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", u.ID).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(u).Error
+	})
+}
+
+// ensureAdminRemains не дает удалить или понизить последнего администратора.
+// newRole - роль пользователя после изменения, пустая при удалении.
+func ensureAdminRemains(db *gorm.DB, u *User, newRole string) error {
+	if u.Role != RoleAdmin || newRole == RoleAdmin {
+		return nil
+	}
+	var others int64
+	if err := db.Model(&User{}).Where("role = ? AND id <> ?", RoleAdmin, u.ID).Count(&others).Error; err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// CreateSession открывает сессию пользователя и возвращает токен для cookie.
+func CreateSession(db *gorm.DB, u *User) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+	s := Session{
+		ID:        hashToken(token),
+		UserID:    u.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+	}
+	if err := db.Create(&s).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	// Заодно чистим просроченные сессии
+	if err := db.Where("expires_at < ?", now).Delete(&Session{}).Error; err != nil {
+		log.Printf("Auth: failed to delete expired sessions: %v", err)
+	}
+	if err := db.Model(u).Update("last_login_at", now).Error; err != nil {
+		log.Printf("Auth: failed to update last login of %s: %v", u.Username, err)
+	}
+	return token, s.ExpiresAt, nil
+}
+
+// SessionUser возвращает пользователя действующей сессии.
+func SessionUser(db *gorm.DB, token string) (*User, error) {
+	var s Session
+	if err := db.First(&s, "id = ? AND expires_at > ?", hashToken(token), time.Now()).Error; err != nil {
+		return nil, err
+	}
+	var u User
+	if err := u.Get(db, s.UserID); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DeleteSession завершает сессию по токену.
+func DeleteSession(db *gorm.DB, token string) error {
+	return db.Delete(&Session{}, "id = ?", hashToken(token)).Error
+}
+
+// DeleteUserSessions завершает все сессии пользователя, кроме keepToken.
+func DeleteUserSessions(db *gorm.DB, userID, keepToken string) error {
+	return db.Where("user_id = ? AND id <> ?", userID, hashToken(keepToken)).Delete(&Session{}).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureAdmin создает первого администратора, если пользователей еще нет.
+// Имя и пароль берутся из PROXYCHECKER_ADMIN_USER/PROXYCHECKER_ADMIN_PASSWORD,
+// затем из старой пары Username/Password в настройках; иначе пароль
+// генерируется и выводится в лог. Старые колонки с паролем в открытом
+// виде удаляются.
+func EnsureAdmin(db *gorm.DB) error {
+	legacyUser, legacyPassword, err := legacyCredentials(db)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		username, password := os.Getenv(envAdminUser), os.Getenv(envAdminPassword)
+		source := "environment"
+		// Заводская пара из старых настроек известна всем - вместо переноса генерируем пароль
+		if legacyUser == legacyDefaultUsername && legacyPassword == legacyDefaultPassword {
+			log.Printf("Auth: legacy settings credentials are the factory defaults, not migrating them")
+		} else if password == "" && legacyUser != "" && legacyPassword != "" {
+			username, password, source = legacyUser, legacyPassword, "legacy settings credentials"
+		}
+		if username == "" {
+			username = "admin"
+		}
+		generated := password == ""
+		if generated {
+			buf := make([]byte, 12)
+			if _, err := rand.Read(buf); err != nil {
+				return err
+			}
+			password = hex.EncodeToString(buf)
+		}
+
+		u := User{ID: uuid.NewString(), Username: username, Role: RoleAdmin, CreatedAt: time.Now()}
+		// Пароль из старых настроек может быть короче нового минимума - хешируем как есть
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = string(hash)
+		if err := u.Save(db); err != nil {
+			return err
+		}
+		if generated {
+			log.Printf("Auth: created admin user %q with password %q - change it after the first login", username, password)
+		} else {
+			log.Printf("Auth: created admin user %q from %s", username, source)
+		}
+		if password == legacyDefaultPassword {
+			log.Printf("Auth: WARNING admin user %q still uses the default password, change it", username)
+		}
+	}
+
+	for _, column := range []string{"username", "password"} {
+		if db.Migrator().HasColumn(&Settings{}, column) {
+			// Migrator().DropColumn в SQLite молча пропускает колонки, которых нет в модели
+			if err := db.Exec("ALTER TABLE settings DROP COLUMN " + column).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Имя и пароль, которые старые версии записывали в настройки по умолчанию
+const (
+	legacyDefaultUsername = "default_username"
+	legacyDefaultPassword = "default_password"
+)
+
+// legacyCredentials читает пару логин/пароль, которую раньше хранили настройки.
+func legacyCredentials(db *gorm.DB) (username, password string, err error) {
+	m := db.Migrator()
+	if !m.HasColumn(&Settings{}, "username") || !m.HasColumn(&Settings{}, "password") {
+		return "", "", nil
+	}
+	var row struct {
+		Username string
+		Password string
+	}
+	if err := db.Table("settings").Select("username, password").Where("id = ?", 1).Limit(1).Scan(&row).Error; err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(row.Username), row.Password, nil
+}